@@ -0,0 +1,91 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// A component with the given number of sections, each with props, a loop and
+// a condition chain. Minified, it is a single line like production SSR output.
+func syntheticComponent(sections int, minified bool) string {
+	var html strings.Builder
+	for i := range sections {
+		fmt.Fprintf(&html, `<section class="s%d"><h2>svelte-section%d-title--</h2>`, i, i)
+		fmt.Fprintf(&html, `<p class="if-section%d-visible--">svelte-section%d-count{int}-- of svelte-section%d-total{float}--</p>`, i, i, i)
+		fmt.Fprintf(&html, `<p class="elif-section%d-hidden--">hidden</p><p class="else--">svelte-section%d-fallback--</p>`, i, i)
+		fmt.Fprintf(&html, `<ul class="iter-section%d-items[item,i]--"><li>svelte-i--: svelte-section%d-items{[]}-label-- svelte-section%d-items{[]}-tags{[]string}--</li></ul>`, i, i, i)
+		html.WriteString("</section>")
+		if !minified {
+			html.WriteString("\n")
+		}
+	}
+	return html.String() + "\n"
+}
+
+// Many small components and a few large minified ones
+func syntheticCorpus(components int) fstest.MapFS {
+	input := fstest.MapFS{}
+	for i := range components {
+		name := fmt.Sprintf("pages/p%d/component%d", i%10, i)
+		input[name+".html"] = component(syntheticComponent(10, false))
+		input[name+".head"] = component(`<link href="/assets/app.css" rel="stylesheet">`)
+	}
+	for i := range components / 50 {
+		name := fmt.Sprintf("large/component%d", i)
+		input[name+".html"] = component(syntheticComponent(500, true))
+		input[name+".head"] = component("")
+	}
+	return input
+}
+
+func benchmarkBuild(b *testing.B, jobs int) {
+	input := syntheticCorpus(200)
+	b.ResetTimer()
+	for range b.N {
+		opts := &BuildOptions{Input: input, Output: &MemoryOutput{}, Jobs: jobs, NoCache: true}
+		if err := Build(context.Background(), opts); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkBuild(b *testing.B) {
+	benchmarkBuild(b, 0)
+}
+
+func BenchmarkBuildSingleJob(b *testing.B) {
+	benchmarkBuild(b, 1)
+}
+
+// Rebuilding with every component cached, as in watch mode
+func BenchmarkBuildCached(b *testing.B) {
+	input := syntheticCorpus(200)
+	output := &MemoryOutput{}
+	if err := Build(context.Background(), &BuildOptions{Input: input, Output: output}); err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for range b.N {
+		if err := Build(context.Background(), &BuildOptions{Input: input, Output: output}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGenerateLargeComponent(b *testing.B) {
+	src := &source{Name: "large", Path: "large.html", HTML: []byte(syntheticComponent(2000, true))}
+	opts, err := resolveOptions(&BuildOptions{Input: fstest.MapFS{}, Output: &MemoryOutput{}})
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(src.HTML)))
+	b.ResetTimer()
+	for range b.N {
+		if _, _, err := generateComponent(context.Background(), src, opts); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -2,187 +2,512 @@ package builder
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"io/fs"
 	"os"
 	"path"
-	"path/filepath"
 	"regexp"
+	"runtime"
 	"sort"
 	"strings"
 	"svelte-ssr-to-templ/builder/parser"
 	"svelte-ssr-to-templ/builder/types"
+	"sync"
 
 	"golang.org/x/sync/errgroup"
 )
 
-var findPropertyRegex = regexp.MustCompile(`svelte-[a-zA-Z0-9-\[\]{}, ]+-`)
-var regexWithQuotes = regexp.MustCompile(`["']{ props.[a-zA-Z0-9.]+ }["']`)
+// Prop markers are made of dash separated parts, `name{type}=key`, and end
+// with a dash. Spaces are only allowed within the type, so that the markers
+// do not run into the next class.
+const propertyPattern = `(?:[a-zA-Z0-9_=\[\]\-]|\{[a-zA-Z0-9_\[\]{}, ]*\})+-`
 
-var typeRegex = regexp.MustCompile(`((\[\])?(string|int|bool))|({(string|int|bool), (\[\])?(string|int|bool)})|(\[\])`)
+// Svelte scopes the CSS of a component with a `svelte-[hash]` class, which
+// the default prop prefix can be mistaken for.
+var scopingHashRegex = regexp.MustCompile(`^[a-z0-9]{5,8}$`)
+var regexWithQuotes = regexp.MustCompile(`["']{ props.[a-zA-Z0-9_.]+ }["']`)
+
+// The first line of the generated files
+const generatedHeader = "// Code generated by svelte-ssr-to-templ. DO NOT EDIT."
 
 var newLine = regexp.MustCompile(`\s+`)
 var catWhiskers = regexp.MustCompile(`> <`)
 
 type BuildOptions struct {
+	// The components are read from Input, or from QueueDir when it is not set.
+	// The generated files are written to Output, or to OutputBuildDir.
+	Input          fs.FS
+	Output         Output
 	QueueDir       string // Relative path
 	OutputBuildDir string // Relative path
-	WaitGroup      *errgroup.Group
+	Jobs           int    // Components built at once, defaults to the number of CPUs
 	Hash           string
+	TimeFormat     string // Go layout used to render time.Time props
+	JSONNaming     string // One of the JSONNaming strategies, defaults to preserve
+	// Import path of the js package in the generated templates, defaults to
+	// the package of this module. Set it when the templates are compiled in a
+	// module that can not resolve it, e.g. to a vendored copy.
+	RuntimePackage string
+	// Prefixes of the prop, loop, map and condition markers, the class of the
+	// else branch and the keywords of the directives, defaulting to the
+	// parser.Default* values
+	PropPrefix    string
+	LoopPrefix    string
+	MapPrefix     string
+	IfPrefix      string
+	ElifPrefix    string
+	ElseMarker    string
+	EachDirective string
+	EndDirective  string
+	Warnings      io.Writer // If set, diagnostics about suspicious markers are written to it
+	// Largest input file in bytes, defaults to DefaultMaxInputSize. Larger
+	// files fail with an error instead of being read into memory.
+	MaxInputSize int64
+	// Keep building the other components when one fails, removing the outputs
+	// of the failed component. Otherwise no more files are started after the
+	// first error.
+	ContinueOnError bool
+	Summary         io.Writer // If set, a table of the built components is written to it
+	// Rebuild every component, instead of skipping the components whose
+	// inputs, options and generator version match the cache manifest, and
+	// whose outputs were not changed since.
+	NoCache bool
+	// Keep the outputs of components in the cache manifest that no longer have
+	// an input, instead of removing them after a successful build.
+	NoPrune bool
 }
 
-// Recursively process all files in the queue directory
-func Build(opts *BuildOptions) {
-	_, err := os.Stat(opts.QueueDir)
-	if os.IsNotExist(err) {
-		log.Fatalf("Queue directory does not exist: %s", opts.QueueDir)
+// Inputs are read whole, whatever the length of their lines
+const DefaultMaxInputSize = 256 << 20
+
+// Strategies deriving the JSON keys of the props from the prop names. A key
+// given in the marker, `svelte-name=key--`, always takes precedence.
+const (
+	JSONNamingPreserve = "preserve"
+	JSONNamingCamel    = "camel"
+	JSONNamingSnake    = "snake"
+	// Every prop must give its key in the marker
+	JSONNamingExplicit = "explicit"
+)
+
+// Recursively process all files in the queue directory. The returned error
+// joins a *FileError for every file that could not be processed, or is
+// ctx.Err() when the build was cancelled.
+func Build(ctx context.Context, opts *BuildOptions) error {
+	opts, err := resolveOptions(opts)
+	if err != nil {
+		return err
+	}
+	components, err := findComponents(opts.Input)
+	if err != nil {
+		return err
 	}
+	if _, err := buildComponents(ctx, opts, components); err != nil {
+		return err
+	}
+	if opts.NoPrune {
+		return nil
+	}
+	return pruneComponents(opts.Output, components)
+}
 
-	err = filepath.Walk(opts.QueueDir, func(p string, info os.FileInfo, err error) error {
-		if info == nil || info.IsDir() {
-			return nil
+// The defaults are resolved on a copy, leaving the options of the caller
+// untouched.
+func resolveOptions(opts *BuildOptions) (*BuildOptions, error) {
+	resolved := *opts
+	opts = &resolved
+	if opts.Input == nil {
+		_, err := os.Stat(opts.QueueDir)
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("queue directory does not exist: %s", opts.QueueDir)
 		}
-		filename := info.Name()
-		if strings.HasSuffix(filename, ".html") {
-			// Remove the queue directory from the path
-			p = strings.ReplaceAll(path.Clean(p), "\\", "/")
-			p = strings.TrimPrefix(p, opts.QueueDir)
-			p = strings.TrimSuffix(p, filename)
-
-			opts.WaitGroup.Go(func() error {
-				processHTMLFile(p, info.Name(), opts)
-				return nil
-			})
+		opts.Input = os.DirFS(opts.QueueDir)
+	}
+	if opts.Output == nil {
+		if opts.OutputBuildDir == "" {
+			return nil, errors.New("no output directory given")
+		}
+		opts.Output = DirOutput(opts.OutputBuildDir)
+	}
+	if err := validateJSONNaming(opts.JSONNaming); err != nil {
+		return nil, err
+	}
+	if opts.JSONNaming == "" {
+		opts.JSONNaming = JSONNamingPreserve
+	}
+	if opts.TimeFormat == "" {
+		opts.TimeFormat = types.DefaultTimeFormat
+	}
+	if opts.RuntimePackage == "" {
+		opts.RuntimePackage = types.RuntimePackage
+	}
+	if opts.MaxInputSize <= 0 {
+		opts.MaxInputSize = DefaultMaxInputSize
+	}
+	syntax := newSyntax(opts)
+	opts.PropPrefix, opts.LoopPrefix, opts.MapPrefix = syntax.PropPrefix, syntax.LoopPrefix, syntax.MapPrefix
+	opts.IfPrefix, opts.ElifPrefix, opts.ElseMarker = syntax.IfPrefix, syntax.ElifPrefix, syntax.ElseMarker
+	opts.EachDirective, opts.EndDirective = syntax.EachDirective, syntax.EndDirective
+	return opts, nil
+}
+
+// Find the components of the input, e.g. `pages/home` for
+// `pages/home.html`, in order.
+func findComponents(input fs.FS) ([]string, error) {
+	var components []string
+	err := fs.WalkDir(input, ".", func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.IsDir() && strings.HasSuffix(p, ".html") {
+			components = append(components, strings.TrimSuffix(p, ".html"))
 		}
 		return nil
 	})
 	if err != nil {
-		log.Fatalf("Error walking queue directory: %s", err)
+		return nil, fmt.Errorf("error walking input: %w", err)
 	}
-	err = opts.WaitGroup.Wait()
+	return components, nil
+}
+
+// The files of a component, read once and passed through the pipeline.
+type source struct {
+	Dir  string // Directory of the component, e.g. `pages/`
+	Name string // Name of the component and its package, e.g. `home`
+	Path string // Path of the `.html` file in errors
+	HTML []byte
+	Head []byte
+}
+
+func readSource(opts *BuildOptions, component string) (*source, error) {
+	dir, name := path.Split(component)
+	src := &source{Dir: dir, Name: name, Path: sourcePath(opts, component+".html")}
+	var err error
+	src.HTML, err = readInput(opts, component+".html")
+	if err != nil {
+		return nil, err
+	}
+	src.Head, err = readInput(opts, component+".head")
 	if err != nil {
-		log.Fatalf("Error processing files: %s", err)
+		return nil, fmt.Errorf("error opening head file: %w", err)
 	}
+	return src, nil
 }
 
-func processHTMLFile(path string, filename string, opts *BuildOptions) {
-	packageName := strings.TrimSuffix(filename, ".html")
-	var props map[string]*parser.Property
-	wg := errgroup.Group{}
-	wg.Go(func() error {
-		dir := opts.OutputBuildDir + path + packageName
-		err := os.MkdirAll(dir, os.ModePerm)
-		return err
+// Read an input file, failing if it is larger than opts.MaxInputSize.
+func readInput(opts *BuildOptions, name string) ([]byte, error) {
+	file, err := opts.Input.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, opts.MaxInputSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > opts.MaxInputSize {
+		return nil, fmt.Errorf("file is larger than the limit of %d bytes", opts.MaxInputSize)
+	}
+	return data, nil
+}
+
+// Build the components, at most opts.Jobs at once, returning the result of
+// every component that was started.
+func buildComponents(ctx context.Context, opts *BuildOptions, components []string) ([]*fileResult, error) {
+	cache, err := loadManifest(opts.Output)
+	if err != nil {
+		return nil, err
+	}
+
+	var mutex sync.Mutex
+	cacheChanged := false
+	var fileErrors []*FileError
+	var results []*fileResult
+	stopped := func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return len(fileErrors) > 0 && !opts.ContinueOnError
+	}
+
+	jobs := opts.Jobs
+	if jobs <= 0 {
+		jobs = runtime.GOMAXPROCS(0)
+	}
+	group := errgroup.Group{}
+	group.SetLimit(jobs)
+
+	for _, component := range components {
+		if stopped() || ctx.Err() != nil {
+			break
+		}
+
+		// Errors are collected instead of cancelling the files already being
+		// processed.
+		group.Go(func() error {
+			if stopped() || ctx.Err() != nil {
+				return nil
+			}
+			result := &fileResult{Component: component}
+			var entry *manifestEntry
+			src, err := readSource(opts, component)
+			if err == nil {
+				entry = newManifestEntry(opts, src)
+				mutex.Lock()
+				cached := cache.Components[component]
+				mutex.Unlock()
+				if !opts.NoCache && entry.upToDate(cached, opts.Output, component) {
+					result.Cached = true
+					mutex.Lock()
+					results = append(results, result)
+					mutex.Unlock()
+					return nil
+				}
+				entry.OutputHash, err = processHTMLFile(ctx, src, opts)
+			}
+			if ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+				// Neither a failure nor built, the manifest is left as it was
+				return nil
+			}
+			if err != nil {
+				var fileErr *FileError
+				if !errors.As(err, &fileErr) {
+					fileErr = &FileError{Path: sourcePath(opts, component+".html"), Err: err}
+				}
+				result.Err = fileErr
+				if opts.ContinueOnError {
+					// Leave no half written component behind
+					if err := removeComponentFiles(opts.Output, component); err != nil {
+						fileErr.Err = errors.Join(fileErr.Err, err)
+					}
+				}
+			}
+			mutex.Lock()
+			if result.Err != nil {
+				fileErrors = append(fileErrors, result.Err)
+				// The outputs are still owned, but never up to date
+				cache.Components[component] = &manifestEntry{}
+				cacheChanged = true
+			} else if entry != nil {
+				cache.Components[component] = entry
+				cacheChanged = true
+			}
+			results = append(results, result)
+			mutex.Unlock()
+			return nil
+		})
+	}
+	group.Wait()
+
+	// The manifest is left untouched when everything was cached
+	if cacheChanged {
+		if err := cache.save(opts.Output); err != nil {
+			fileErrors = append(fileErrors, &FileError{Path: ManifestName, Err: err})
+		}
+	}
+
+	if opts.Summary != nil {
+		writeSummary(opts.Summary, results)
+	}
+	if err := ctx.Err(); err != nil {
+		return results, err
+	}
+
+	sort.Slice(fileErrors, func(i, j int) bool {
+		return fileErrors[i].Path < fileErrors[j].Path
 	})
-	wg.Go(func() error {
-		props = parseHTMLFile(path, filename, opts)
+	errs := make([]error, len(fileErrors))
+	for i, fileErr := range fileErrors {
+		errs[i] = fileErr
+	}
+	return results, errors.Join(errs...)
+}
+
+// Split the content of an input file into lines. Unlike bufio.Scanner there
+// is no limit on the length of a line, minified SSR output is often a single
+// line of several megabytes.
+func inputLines(data []byte) []string {
+	if len(data) == 0 {
 		return nil
-	})
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSuffix(line, "\r")
+	}
+	return lines
+}
+
+func validateJSONNaming(naming string) error {
+	switch naming {
+	case "", JSONNamingPreserve, JSONNamingCamel, JSONNamingSnake, JSONNamingExplicit:
+		return nil
+	}
+	return fmt.Errorf("invalid JSON naming strategy: %s", naming)
+}
 
-	err := wg.Wait()
+// The path of an input file in errors, relative to the queue directory when
+// reading from it.
+func sourcePath(opts *BuildOptions, name string) string {
+	return path.Join(opts.QueueDir, name)
+}
+
+// Generate and write the files of the component, returning their hash for
+// the cache manifest.
+func processHTMLFile(ctx context.Context, src *source, opts *BuildOptions) (string, error) {
+	goSource, templSource, err := generateComponent(ctx, src, opts)
 	if err != nil {
-		panic(err)
+		return "", err
+	}
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	// Both files are generated before writing, and the `.go` file is restored
+	// when the `.templ` file can not be written, so that they always come from
+	// the same build.
+	files := componentFiles(src.Dir + src.Name)
+	previous, err := opts.Output.ReadFile(files[0])
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+	if err := opts.Output.WriteFile(files[0], goSource); err != nil {
+		return "", err
+	}
+	if err := opts.Output.WriteFile(files[1], templSource); err != nil {
+		var rollbackErr error
+		if previous == nil {
+			rollbackErr = opts.Output.Remove(files[0])
+		} else {
+			rollbackErr = opts.Output.WriteFile(files[0], previous)
+		}
+		return "", errors.Join(err, rollbackErr)
+	}
+	return hashFiles(goSource, templSource), nil
+}
+
+// Extract the props of the component, then generate the Go source of the
+// props struct and the templ source. The context is checked between stages.
+func generateComponent(ctx context.Context, src *source, opts *BuildOptions) ([]byte, []byte, error) {
+	syntax := newMarkerSyntax(opts)
+	props, html, err := parseHTMLFile(src, syntax, opts.Warnings)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
 	}
 
 	props = promoteProperty(props)
-	opts.WaitGroup.Go(func() error {
-		trimmed := strings.TrimSuffix(filename, ".html")
-		generateStructs(props, path, &trimmed, &packageName, opts)
-		return nil
-	})
-	opts.WaitGroup.Go(func() error {
-		replacePlaceholders(props, path, filename, packageName, opts)
-		return nil
-	})
+	resolveTypes(props)
+	assignGoNames(props)
+	err = assignJSONNames(props, opts.JSONNaming)
+	if err != nil {
+		return nil, nil, &FileError{Path: src.Path, Err: err}
+	}
+
+	goSource, err := generateStructs(props, src, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	templSource, err := replacePlaceholders(props, html, src, syntax, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return goSource, templSource, nil
 }
 
 func promoteProperty(props map[string]*parser.Property) map[string]*parser.Property {
-	// If a child property has only one child, promote the child property to be a
-	// field of the parent property.
+	// If a property only has the empty child left by the trailing `-` of its
+	// marker, promote the property to be a plain field. A property with a
+	// single real child stays a struct, `svelte-user-name--` is read as
+	// `props.User.Name` and flattening it would drop the field.
 	for _, prop := range props {
 		promoteProperty(prop.Children)
 
-		if len(prop.Children) == 1 {
+		if _, exists := prop.Children[""]; exists && len(prop.Children) == 1 {
 			prop.Children = nil
 		}
 	}
 	return props
 }
 
+// Properties with children are generated as structs
+func resolveTypes(props map[string]*parser.Property) {
+	for _, prop := range props {
+		if len(prop.Children) > 0 {
+			prop.Type = prop.Type.WithChildren()
+			resolveTypes(prop.Children)
+		}
+	}
+}
+
+// Generate the templ source from the HTML, whose prop markers are already
+// replaced with `{ props.[propPath] }` expressions.
 func replacePlaceholders(
 	props map[string]*parser.Property,
-	path string,
-	filename string,
-	packageName string,
+	html *markedHTML,
+	src *source,
+	syntax *markerSyntax,
 	opts *BuildOptions,
-) {
-	queueDir := opts.QueueDir
-	outputBuildDir := opts.OutputBuildDir
-
-	wg := errgroup.Group{}
-
-	var inputFile *os.File
-	htmlContent := &strings.Builder{}
-	wg.Go(func() error {
-		var err error
-		inputFile, err = os.Open(queueDir + path + filename)
-		if err != nil {
-			inputFile.Close()
-			return err
-		}
-		filestat, err := inputFile.Stat()
-		if err != nil {
-			inputFile.Close()
-			return err
-		}
-		fileLength := filestat.Size()
-		htmlContent.Grow(int(fileLength))
-
-		return err
-	})
-
-	var outputFile *os.File
-	wg.Go(func() error {
-		outputFilename := outputBuildDir + path + packageName + "/" + strings.TrimSuffix(filename, ".html") + ".templ"
-		var err error
-		outputFile, err = os.Create(outputFilename)
-		return err
-	})
-
-	err := wg.Wait()
+) ([]byte, error) {
+	packageName := src.Name
+	htmlString := html.HTML
+	writer := &bytes.Buffer{}
+
+	// The body is generated first, since it decides the imports
+	body := &strings.Builder{}
+	bodyWriter := bufio.NewWriter(body)
+	var imports []string
+	timeFormat := opts.TimeFormat
+	if timeFormat == "" {
+		timeFormat = types.DefaultTimeFormat
+	}
+	parserOpts := &parser.Options{TimeFormat: timeFormat, Syntax: syntax.Syntax, RuntimePackage: opts.RuntimePackage}
+	var err error
+	if strings.Contains(htmlString, syntax.LoopPrefix) || strings.Contains(htmlString, syntax.MapPrefix) ||
+		strings.Contains(htmlString, syntax.EachDirective) || syntax.conditionRegex.MatchString(htmlString) {
+		htmlString = newLine.ReplaceAllString(htmlString, " ")
+		htmlString = catWhiskers.ReplaceAllString(htmlString, "><")
+		imports, err = parser.Parse(props, strings.NewReader(htmlString), bodyWriter, parserOpts)
+	} else {
+		// The parser unquotes attribute expressions itself, the html tokenizer
+		// would otherwise split them into several attributes.
+		htmlString = regexWithQuotes.ReplaceAllStringFunc(htmlString, func(match string) string {
+			return strings.ReplaceAll(strings.ReplaceAll(match, "\"", ""), "'", "")
+		})
+		htmlString, imports, err = parser.ReplaceExpressions(props, htmlString, parserOpts)
+		bodyWriter.WriteString(htmlString)
+	}
 	if err != nil {
-		if inputFile != nil {
-			inputFile.Close()
-		}
-		if outputFile != nil {
-			outputFile.Close()
-		}
-		panic(err)
+		return nil, markerError(src.Path, string(src.HTML), html.Markers, err)
 	}
-	defer inputFile.Close()
-	defer outputFile.Close()
-
-	scanner := bufio.NewScanner(inputFile)
-	writer := bufio.NewWriterSize(outputFile, htmlContent.Len())
-	defer writer.Flush()
+	bodyWriter.Flush()
 
 	numProps := len(props)
-	var funcInner string
-	var importJSON string = "\n"
+	var funcInner, jsonConfig string
 	if numProps == 0 {
 		funcInner = `	return "{}"`
 	} else {
-		importJSON = `
-import json "github.com/bytedance/sonic"
+		imports = append(imports, `json "github.com/bytedance/sonic"`)
+		// Only sorting the keys of maps, like js.Entries iterates over them,
+		// unlike json.ConfigStd, which also escapes HTML and validates strings
+		jsonConfig = `
+// The default config of sonic, sorting the keys of maps
+var ` + packageName + `JSON = json.Config{SortMapKeys: true}.Froze()
 `
-		funcInner = `jsonProps, err := json.Marshal(*props)
+		funcInner = `jsonProps, err := ` + packageName + `JSON.Marshal(*props)
 	if err != nil {
 		panic(err)
 	}
 	return string(jsonProps)`
 	}
-	writer.WriteString(`// Code generated by svelte-ssr-to-templ. DO NOT EDIT.
-package ` + packageName + importJSON + `
+	writer.WriteString(generatedHeader + `
+package ` + packageName + "\n" + formatImports(imports) + jsonConfig + `
 func marshalProps(props *` + packageName + `Props) string {
 ` + funcInner + `
 }
@@ -195,90 +520,264 @@ func addHeadContent(headContents map[string]struct{}) {
 
 `)
 
-	writer.WriteString("templ Home(props *" + strings.TrimSuffix(filename, ".html") + `Props, headContents map[string]struct{}) {
+	writer.WriteString("templ Home(props *" + packageName + `Props, headContents map[string]struct{}) {
 	{{ addHeadContent(headContents) }}
 `)
 	writer.WriteString("\t<div class=\"" + packageName + "\" data-svelte={ marshalProps(props) }>\n")
+	writer.WriteString(body.String())
+	writer.WriteString("\t</div>\n}\n")
+	return writer.Bytes(), nil
+}
 
-	for scanner.Scan() {
-		line := scanner.Text()
-		modifiedLine := findPropertyRegex.ReplaceAllStringFunc(line, func(match string) string {
-			parts := strings.Split(strings.TrimPrefix(strings.TrimSuffix(match, "--"), "svelte-"), "-")
-
-			// Remove type information from the property path
-			for i, part := range parts {
-				indexStart := strings.Index(part, "{")
-				if indexStart != -1 && strings.HasSuffix(part, "}") {
-					parts[i] = part[:indexStart]
-				}
-			}
-			return "{ props." + strings.Join(parts, ".") + " }"
-		})
-		modifiedLine = regexWithQuotes.ReplaceAllStringFunc(modifiedLine, func(match string) string {
-			return strings.ReplaceAll(strings.ReplaceAll(match, "\"", ""), "'", "")
-		})
-
-		_, err := htmlContent.WriteString(modifiedLine + "\n")
-		if err != nil {
-			panic(err)
-		}
+func formatImports(imports []string) string {
+	switch len(imports) {
+	case 0:
+		return ""
+	case 1:
+		return "\nimport " + quoteImport(imports[0]) + "\n"
 	}
-	htmlString := htmlContent.String()
-	if strings.Contains(htmlString, "iter-") {
-		htmlString = newLine.ReplaceAllString(htmlString, " ")
-		htmlString = catWhiskers.ReplaceAllString(htmlString, "><")
-		parser.Parse(props, strings.NewReader(htmlString), writer)
-	} else {
-		writer.WriteString(htmlString)
+	var builder strings.Builder
+	builder.WriteString("\nimport (\n")
+	for _, name := range imports {
+		builder.WriteString("\t" + quoteImport(name) + "\n")
 	}
-	writer.WriteString("\t</div>\n}\n")
+	builder.WriteString(")\n")
+	return builder.String()
 }
 
-func parseHTMLFile(
-	path string,
-	filename string,
-	opts *BuildOptions,
-) map[string]*parser.Property {
-	file, err := os.Open(opts.QueueDir + path + filename)
-	if err != nil {
-		panic(err)
+// Quote the path of an import, unless it is already quoted behind an alias.
+func quoteImport(name string) string {
+	if strings.HasSuffix(name, `"`) {
+		return name
+	}
+	return `"` + name + `"`
+}
+
+// The HTML of a component with its prop markers replaced by expressions
+type markedHTML struct {
+	HTML string
+	// The position of the first prop marker of every expression, by the
+	// marker the parser reports its errors with, e.g. the position of
+	// `svelte-items{[]}-label--` by `svelte-items-label`.
+	Markers map[string]position
+}
+
+type position struct {
+	line, col int
+}
+
+// Collect the props of the markers in the HTML of the component, and replace
+// the prop markers with `{ props.[propPath] }` expressions for the parser. The
+// HTML is only scanned once.
+func parseHTMLFile(src *source, syntax *markerSyntax, warnings io.Writer) (map[string]*parser.Property, *markedHTML, error) {
+	sourcePath := src.Path
+
+	type marker struct {
+		parts     []string
+		line, col int
 	}
-	defer file.Close()
 
 	props := make(map[string]*parser.Property)
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := scanner.Text()
-		// Find all occurrences of "svelte-" followed by the prop name
-		matches := findPropertyRegex.FindAllString(line, -1)
+	var properties, conditions []marker
+	hasLoops := false
+	markers := make(map[string]position)
+	var html strings.Builder
+	html.Grow(len(src.HTML))
+	for i, line := range inputLines(src.HTML) {
+		lineNumber := i + 1
+		if !hasLoops {
+			hasLoops = len(syntax.FindLoopMarkers(line)) > 0 || len(syntax.FindDirectives(line)) > 0
+		}
+		for _, match := range syntax.conditionRegex.FindAllStringSubmatchIndex(line, -1) {
+			conditions = append(conditions, marker{
+				parts: strings.Split(line[match[6]:match[7]], "-"),
+				line:  lineNumber,
+				col:   match[4] + 1,
+			})
+		}
+		// Find all occurrences of the prefix followed by the prop name
+		matches := syntax.propertyRegex.FindAllStringIndex(line, -1)
+		end := 0
 		for _, match := range matches {
-			// Remove "svelte-" prefix and trailing "-"
-			propPath := strings.TrimPrefix(strings.TrimSuffix(match, "-"), "svelte-")
+			// Remove the prefix and trailing "-"
+			propPath := strings.TrimPrefix(strings.TrimSuffix(line[match[0]:match[1]], "-"), syntax.PropPrefix)
 			// Split the property path, but keep nested levels intact
 			parts := strings.Split(propPath, "-")
-			addProperty(props, &parts)
+			if warnings != nil && syntax.PropPrefix == parser.DefaultPropPrefix && looksLikeScopingHash(parts[0]) {
+				warn(warnings, &FileError{sourcePath, lineNumber, match[0] + 1, fmt.Errorf(
+					"%s looks like the scoping class of Svelte rather than a prop, use another prop prefix if it is one",
+					line[match[0]:match[1]],
+				)})
+			}
+			properties = append(properties, marker{parts, lineNumber, match[0] + 1})
+			names := markerNames(parts)
+			html.WriteString(line[end:match[0]] + "{ props." + strings.Join(names, ".") + " }")
+			text := syntax.PropPrefix + strings.Join(names, "-")
+			if _, exists := markers[text]; !exists {
+				markers[text] = position{lineNumber, match[0] + 1}
+			}
+			end = match[1]
 		}
+		html.WriteString(line[end:] + "\n")
 	}
-	return props
+
+	// The properties are added once all loops are known. The names declared
+	// by a loop only refer to its variables within the loop.
+	var scopes []*loopScope
+	if hasLoops {
+		scopes = findLoopScopes(src.HTML, syntax)
+	}
+	for _, property := range properties {
+		parts := property.parts
+		// The index and key variables of the loops are no props
+		loop := loopDeclaring(scopes, position{property.line, property.col}, parts[0])
+		if loop != nil && loop.ValName != parts[0] && (len(parts) == 1 || len(parts) == 2 && parts[1] == "") {
+			continue
+		}
+		if err := addProperty(props, &parts); err != nil {
+			return nil, nil, &FileError{sourcePath, property.line, property.col, err}
+		}
+	}
+
+	// Conditions are added last, so that a type given by a `svelte-` marker
+	// takes precedence over the default bool.
+	for _, condition := range conditions {
+		parts := condition.parts
+		// Conditions inside of loops may refer to the loop variables
+		if loop := loopDeclaring(scopes, position{condition.line, condition.col}, parts[0]); loop != nil {
+			if len(parts) == 1 {
+				continue
+			}
+			if loop.ValName == parts[0] {
+				parts = append(parser.LoopPath(props, loop), parts[1:]...)
+			}
+		}
+		if err := addCondition(props, parts); err != nil {
+			return nil, nil, &FileError{sourcePath, condition.line, condition.col, err}
+		}
+	}
+	return props, &markedHTML{html.String(), markers}, nil
+}
+
+// The names of the parts of a prop marker, e.g. `user`, `name` for
+// `svelte-user-name{string}--`.
+func markerNames(parts []string) []string {
+	names := make([]string, 0, len(parts))
+	for _, part := range parts {
+		// Remove type information and JSON keys from the property path
+		name, _, _ := splitPart(part)
+		names = append(names, name)
+	}
+	// The empty part of the trailing `--`
+	if len(names) > 1 && names[len(names)-1] == "" {
+		names = names[:len(names)-1]
+	}
+	return names
 }
 
-func addProperty(props map[string]*parser.Property, parts *[]string) {
+func addCondition(props map[string]*parser.Property, parts []string) error {
 	current := props
-	for i, part := range *parts {
-		indexStart := strings.Index(part, "{")
-		var currentType string
-
-		if indexStart != -1 && strings.HasSuffix(part, "}") {
-			indexEnd := len(part) - 1
-			typeString := part[indexStart+1 : indexEnd]
-			if !typeRegex.MatchString(typeString) {
-				log.Fatalf("Invalid type: %s", typeString)
+	for i, part := range parts {
+		prop, exists := current[part]
+		if i == len(parts)-1 {
+			if !exists {
+				current[part] = &parser.Property{Name: part, Type: types.NewScalar("bool")}
+			}
+			return nil
+		}
+
+		if !exists {
+			prop = &parser.Property{
+				Name:     part,
+				Type:     types.NewScalar(types.DefaultType),
+				Children: make(map[string]*parser.Property),
 			}
+			current[part] = prop
+		} else if prop.Children == nil {
+			return fmt.Errorf("condition %s refers to a field of %s, which has no fields", strings.Join(parts, "-"), part)
+		}
+		current = prop.Children
+	}
+	return nil
+}
+
+func newSyntax(opts *BuildOptions) *parser.Syntax {
+	return parser.NewSyntax(parser.Syntax{
+		PropPrefix:    opts.PropPrefix,
+		LoopPrefix:    opts.LoopPrefix,
+		MapPrefix:     opts.MapPrefix,
+		IfPrefix:      opts.IfPrefix,
+		ElifPrefix:    opts.ElifPrefix,
+		ElseMarker:    opts.ElseMarker,
+		EachDirective: opts.EachDirective,
+		EndDirective:  opts.EndDirective,
+	})
+}
+
+// The syntax of the markers, with the regexes finding the prop and condition
+// markers in the HTML.
+type markerSyntax struct {
+	*parser.Syntax
+	propertyRegex  *regexp.Regexp
+	conditionRegex *regexp.Regexp
+}
+
+func newMarkerSyntax(opts *BuildOptions) *markerSyntax {
+	syntax := newSyntax(opts)
+	return &markerSyntax{
+		Syntax:        syntax,
+		propertyRegex: regexp.MustCompile(regexp.QuoteMeta(syntax.PropPrefix) + propertyPattern),
+		// The keyword is group 2, the path group 3
+		conditionRegex: regexp.MustCompile(`(^|[\s"'])(` + regexp.QuoteMeta(syntax.IfPrefix) + `|` +
+			regexp.QuoteMeta(syntax.ElifPrefix) + `)([a-zA-Z0-9_]+(-[a-zA-Z0-9_]+)*)--`),
+	}
+}
+
+// Scoping hashes are short, lower case and contain a digit, unlike most prop
+// names.
+func looksLikeScopingHash(name string) bool {
+	return scopingHashRegex.MatchString(name) && strings.ContainsAny(name, "0123456789")
+}
+
+var warningMutex sync.Mutex
+
+// Write a warning, which may come from any of the files built at once.
+func warn(w io.Writer, err *FileError) {
+	warningMutex.Lock()
+	defer warningMutex.Unlock()
+	fmt.Fprintf(w, "warning: %s\n", err)
+}
+
+// Split a part of a property path, `name{type}=jsonKey`, into the name, the
+// type annotation and the JSON key. The type and key are optional.
+func splitPart(part string) (string, string, string) {
+	var jsonKey string
+	if index := strings.LastIndex(part, "="); index != -1 && !strings.Contains(part[index:], "}") {
+		jsonKey = part[index+1:]
+		part = part[:index]
+	}
 
-			part = part[:indexStart]
-			currentType = typeString
+	indexStart := strings.Index(part, "{")
+	if indexStart != -1 && strings.HasSuffix(part, "}") {
+		return part[:indexStart], part[indexStart+1 : len(part)-1], jsonKey
+	}
+	return part, "", jsonKey
+}
+
+func addProperty(props map[string]*parser.Property, parts *[]string) error {
+	current := props
+	for i, part := range *parts {
+		part, typeString, jsonKey := splitPart(part)
+		var currentType *types.Type
+
+		if typeString != "" {
+			parsed, err := types.Parse(typeString)
+			if err != nil {
+				return err
+			}
+			currentType = parsed
 		} else {
-			currentType = types.DefaultType
+			currentType = types.NewScalar(types.DefaultType)
 		}
 
 		if i == len(*parts)-1 {
@@ -293,47 +792,37 @@ func addProperty(props map[string]*parser.Property, parts *[]string) {
 					Children: make(map[string]*parser.Property),
 				}
 			}
-			current = current[part].Children
 		}
+		if jsonKey != "" && current[part].JSONName == "" {
+			current[part].JSONName = jsonKey
+		}
+		current = current[part].Children
 	}
+	return nil
 }
 
 func generateStructs(
 	props map[string]*parser.Property,
-	path string,
-	filename *string,
-	packageName *string,
+	src *source,
 	opts *BuildOptions,
-) {
-	queueDir := opts.QueueDir
-	outputBuildDir := opts.OutputBuildDir
-
-	// Create the directory recursively if it doesn't exist
-	outputDir := outputBuildDir + path + *packageName
-	err := os.MkdirAll(outputDir, os.ModePerm)
-	if err != nil {
-		log.Fatalf("Error creating directory: %s", err)
+) ([]byte, error) {
+	filename := &src.Name
+	packageName := &src.Name
+	outputFile := &bytes.Buffer{}
+
+	var imports string
+	if usesType(props, "time") {
+		imports = "import \"time\"\n\n"
 	}
 
-	outputFile, err := os.Create(outputDir + "/" + *filename + ".go")
-	if err != nil {
-		log.Fatalf("Error creating file: %s", err)
-	}
-	defer outputFile.Close()
-
-	fmt.Fprintf(outputFile, `// Code generated by svelte-ssr-to-templ. DO NOT EDIT.
+	fmt.Fprintf(outputFile, generatedHeader+`
 
 package %s
 
-type %sProps struct {
-`, *packageName, *filename)
+%stype %sProps struct {
+`, *packageName, imports, *filename)
 
-	// Sort the properties by name
-	var propNames []string
-	for name := range props {
-		propNames = append(propNames, name)
-	}
-	sort.Strings(propNames)
+	propNames := parser.SortedNames(props)
 
 	var parentName string = ""
 
@@ -343,24 +832,16 @@ type %sProps struct {
 	}
 	fmt.Fprint(outputFile, "}\n\n")
 
-	for _, prop := range props {
+	for _, name := range propNames {
+		prop := props[name]
 		if len(prop.Children) > 0 {
 			generateNestedStructs(outputFile, prop, filename, &parentName)
 		}
 	}
 
-	// Read the .head file in the same directory
-	headFile, err := os.Open(queueDir + path + *filename + ".head")
-	if err != nil {
-		log.Fatalf("Error opening head file: %s", err)
-	}
-	defer headFile.Close()
-
 	// Create a go array of strings from the head file
-	scanner := bufio.NewScanner(headFile)
 	fmt.Fprintf(outputFile, "var %sHead = [...]string{\n", *filename)
-	for scanner.Scan() {
-		text := scanner.Text()
+	for _, text := range inputLines(src.Head) {
 		// Find the "/assets/[filename].ext" part of the string and replace it with
 		// "/assets/[filename]-[gitHash].ext"
 		indexStart := strings.Index(text, `href="/assets/`)
@@ -376,53 +857,45 @@ type %sProps struct {
 		fmt.Fprintf(outputFile, "\t`%s-%s%s`,\n", text[:extIndex], opts.Hash, text[extIndex:])
 	}
 	fmt.Fprintln(outputFile, "}")
+	return outputFile.Bytes(), nil
 }
 
-func generateFields(outputFile *os.File, prop *parser.Property, prefix *string, parentName *string) {
-	// TODO(czarlinski): maybe make this omit empty.
-	nameWithLower := strings.ToLower(prop.Name[:1]) + prop.Name[1:]
-	jsonTag := fmt.Sprintf("`json:\"%s\"`", nameWithLower)
-	if len(prop.Children) == 0 {
-		fmt.Fprintf(
-			outputFile,
-			"\t%s %s %s\n",
-			prop.Name, types.FieldTypeMap[prop.Type], jsonTag,
-		)
-	} else if prop.Type == "[]" {
-		fmt.Fprintf(
-			outputFile,
-			"\t%s []%s%s%s %s\n",
-			prop.Name, *prefix, *parentName, prop.Name, jsonTag,
-		)
-	} else {
-		fmt.Fprintf(
-			outputFile,
-			"\t%s %s%s%s %s\n",
-			prop.Name, *prefix, *parentName, prop.Name, jsonTag,
-		)
+// Whether any of the properties uses the scalar type
+func usesType(props map[string]*parser.Property, name string) bool {
+	for _, prop := range props {
+		if prop.Type.Uses(name) || usesType(prop.Children, name) {
+			return true
+		}
 	}
+	return false
+}
+
+func generateFields(outputFile io.Writer, prop *parser.Property, prefix *string, parentName *string) {
+	// TODO(czarlinski): maybe make this omit empty.
+	jsonTag := fmt.Sprintf("`json:\"%s\"`", prop.JSONName)
+	fmt.Fprintf(
+		outputFile,
+		"\t%s %s %s\n",
+		prop.GoName, prop.Type.GoTypeOf(*prefix+*parentName+prop.GoName), jsonTag,
+	)
 }
 
-func generateNestedStructs(outputFile *os.File, prop *parser.Property, prefix *string, parentName *string) {
+func generateNestedStructs(outputFile io.Writer, prop *parser.Property, prefix *string, parentName *string) {
 	if len(prop.Children) > 0 {
-		fmt.Fprintf(outputFile, "type %s%s%s struct {\n", *prefix, *parentName, prop.Name)
-		// Sort the properties by name
-		var propNames []string
-		for name := range prop.Children {
-			propNames = append(propNames, name)
-		}
-		sort.Strings(propNames)
+		fmt.Fprintf(outputFile, "type %s%s%s struct {\n", *prefix, *parentName, prop.GoName)
+		propNames := parser.SortedNames(prop.Children)
 
 		for _, name := range propNames {
 			child := prop.Children[name]
-			var newParentName string = *parentName + prop.Name
+			var newParentName string = *parentName + prop.GoName
 			generateFields(outputFile, child, prefix, &newParentName)
 		}
 		fmt.Fprint(outputFile, "}\n\n")
 
-		for _, child := range prop.Children {
+		for _, name := range propNames {
+			child := prop.Children[name]
 			if len(child.Children) > 0 {
-				var newParentName string = *parentName + prop.Name
+				var newParentName string = *parentName + prop.GoName
 				generateNestedStructs(outputFile, child, prefix, &newParentName)
 			}
 		}
@@ -0,0 +1,425 @@
+package builder
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"svelte-ssr-to-templ/builder/parser"
+	"testing"
+	"testing/fstest"
+)
+
+// Components with nested props, loops and conditions, whose props are
+// collected in maps.
+var testInput = fstest.MapFS{
+	"pages/home.html": {Data: []byte(
+		`<h1>svelte-title--</h1><p>svelte-user-name-- svelte-user-age{int}-- svelte-user-address-city--</p>` + "\n" +
+			`<ul class="iter-items[item]--"><li class="if-item-done--">svelte-items{[]}-label--</li><li class="else--">svelte-items{[]}-tags{[]string}--</li></ul>` + "\n" +
+			`<p class="if-user-admin--">svelte-count{int}--</p><p class="elif-user-guest--">svelte-zone--</p>` + "\n",
+	)},
+	"pages/home.head": {Data: []byte(
+		`<link href="/assets/app.css" rel="stylesheet">` + "\n" +
+			`<script src="/assets/app.js"></script>` + "\n" +
+			`<meta name="description" content="Home">` + "\n",
+	)},
+	"pages/about.html": {Data: []byte(`<p>svelte-b-- svelte-a-- svelte-c-d-- svelte-c-e{float}--</p>` + "\n")},
+	"pages/about.head": {Data: []byte("")},
+}
+
+func TestPromoteProperty(t *testing.T) {
+	goSource, templSource, err := Transform(
+		`<p>svelte-user-name--</p><p>svelte-title--</p>`, nil, "home", nil,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		"\tTitle string `json:\"title\"`\n",
+		"\tUser homeUser `json:\"user\"`\n",
+		"type homeUser struct {\n\tName string `json:\"name\"`\n}\n",
+	} {
+		if !strings.Contains(goSource, want) {
+			t.Errorf("Go source does not contain %q:\n%s", want, goSource)
+		}
+	}
+	if !strings.Contains(templSource, "{ props.User.Name }") {
+		t.Errorf("templ source does not read props.User.Name:\n%s", templSource)
+	}
+}
+
+func TestScalarTypes(t *testing.T) {
+	tests := []struct {
+		marker     string
+		field      string
+		conversion string
+	}{
+		{"svelte-count{int64}--", "\tCount int64 `json:\"count\"`\n", "{ js.Stringify(props.Count) }"},
+		{"svelte-size{uint}--", "\tSize uint `json:\"size\"`\n", "{ js.Stringify(props.Size) }"},
+		{"svelte-ratio{float}--", "\tRatio float64 `json:\"ratio\"`\n", "{ js.Stringify(props.Ratio) }"},
+		{"svelte-at{time}--", "\tAt time.Time `json:\"at\"`\n", `{ props.At.Format("2006-01-02T15:04:05.999999999Z07:00") }`},
+		{"svelte-days{[]time}--", "\tDays []time.Time `json:\"days\"`\n", "{ js.Stringify(props.Days) }"},
+	}
+	for _, test := range tests {
+		goSource, templSource, err := Transform("<p>"+test.marker+"</p>", nil, "home", nil)
+		if err != nil {
+			t.Errorf("%s: %v", test.marker, err)
+			continue
+		}
+		if !strings.Contains(goSource, test.field) {
+			t.Errorf("%s: Go source does not contain %q:\n%s", test.marker, test.field, goSource)
+		}
+		// Only the fields of times need the time package
+		if usesTime := strings.Contains(test.field, "time.Time"); strings.Contains(goSource, `import "time"`) != usesTime {
+			t.Errorf("%s: Go source imports time is %v, want %v:\n%s", test.marker, !usesTime, usesTime, goSource)
+		}
+		if !strings.Contains(templSource, test.conversion) {
+			t.Errorf("%s: templ source does not contain %q:\n%s", test.marker, test.conversion, templSource)
+		}
+	}
+}
+
+func TestTimeFormat(t *testing.T) {
+	_, templSource, err := Transform(
+		`<p>svelte-at{time}-- svelte-days{[]time}-- svelte-dates{{string, time}}--</p>`,
+		nil, "home", &BuildOptions{TimeFormat: "2006-01-02"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`{ props.At.Format("2006-01-02") }`,
+		`{ js.StringifyTime(props.Days, "2006-01-02") }`,
+		`{ js.StringifyTime(props.Dates, "2006-01-02") }`,
+	} {
+		if !strings.Contains(templSource, want) {
+			t.Errorf("templ source does not contain %q:\n%s", want, templSource)
+		}
+	}
+}
+
+func TestRuntimePackage(t *testing.T) {
+	tests := []struct {
+		runtimePackage string
+		want           string
+	}{
+		{"", "\t\"svelte-ssr-to-templ/js\"\n"},
+		{"example.com/app/internal/js", "\t\"example.com/app/internal/js\"\n"},
+		{"example.com/app/jsruntime", "\tjs \"example.com/app/jsruntime\"\n"},
+	}
+	for _, test := range tests {
+		opts := &BuildOptions{RuntimePackage: test.runtimePackage}
+		_, templSource, err := Transform(`<p>svelte-count{int}--</p>`, nil, "home", opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(templSource, test.want) {
+			t.Errorf("runtime package %q: templ source does not contain %q:\n%s", test.runtimePackage, test.want, templSource)
+		}
+	}
+}
+
+func TestBuildIsReproducible(t *testing.T) {
+	var outputs []map[string][]byte
+	for range 2 {
+		output := &MemoryOutput{}
+		err := Build(context.Background(), &BuildOptions{Input: testInput, Output: output, Jobs: 4})
+		if err != nil {
+			t.Fatal(err)
+		}
+		outputs = append(outputs, output.Files())
+	}
+
+	first, second := outputs[0], outputs[1]
+	if len(first) != len(second) {
+		t.Fatalf("built %d and %d files", len(first), len(second))
+	}
+	for name, data := range first {
+		if !bytes.Equal(data, second[name]) {
+			t.Errorf("%s differs between builds:\n%s", name, UnifiedDiff(name, data, second[name]))
+		}
+	}
+	if _, exists := first["pages/home/home.go"]; !exists {
+		t.Errorf("pages/home/home.go was not built, got %d files", len(first))
+	}
+}
+
+func TestElseIfConditionProps(t *testing.T) {
+	goSource, templSource, err := Transform(
+		`<p class="if-admin--">A</p><p class="elif-user-guest--">B</p><p class="else--">C</p>`, nil, "home", nil,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"\tAdmin bool `json:\"admin\"`\n", "\tGuest bool `json:\"guest\"`\n"} {
+		if !strings.Contains(goSource, want) {
+			t.Errorf("Go source does not contain %q:\n%s", want, goSource)
+		}
+	}
+	if !strings.Contains(templSource, "} else if props.User.Guest {") {
+		t.Errorf("templ source does not contain the else if branch:\n%s", templSource)
+	}
+}
+
+func TestFailedComponentKeepsNestedComponents(t *testing.T) {
+	input := nestedInput()
+	output := &MemoryOutput{}
+	opts := &BuildOptions{Input: input, Output: output, ContinueOnError: true}
+	if err := Build(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+
+	input["routes.html"] = component(`<p>svelte-title{nope}--</p>`)
+	err := Build(context.Background(), opts)
+	if err == nil || !strings.Contains(err.Error(), "routes.html") {
+		t.Fatalf("expected an error for routes.html, got %v", err)
+	}
+	assertFiles(t, output,
+		[]string{"routes/about/about.go", "routes/about/about.templ"},
+		[]string{"routes/routes.go", "routes/routes.templ"},
+	)
+}
+
+func TestFailedWriteKeepsFilesConsistent(t *testing.T) {
+	input := nestedInput()
+	output := &flakyOutput{}
+	opts := &BuildOptions{Input: input, Output: output}
+	if err := Build(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	built := output.Files()
+
+	input["routes/about.html"] = component(`<p>svelte-name-- svelte-age{int}--</p>`)
+	output.failures, output.suffix = 1, ".templ"
+	if err := Build(context.Background(), opts); err == nil {
+		t.Fatal("expected the write of the templ file to fail")
+	}
+	for _, name := range componentFiles("routes/about") {
+		if !bytes.Equal(output.Files()[name], built[name]) {
+			t.Errorf("%s is not the one of the previous build", name)
+		}
+	}
+
+	// A new component leaves no `.go` file without its `.templ` file
+	input["blog.html"], input["blog.head"] = component(`<p>svelte-title--</p>`), component("")
+	output.failures = 1
+	if err := Build(context.Background(), opts); err == nil {
+		t.Fatal("expected the write of the templ file to fail")
+	}
+	assertFiles(t, &output.MemoryOutput, nil, []string{"blog/blog.go"})
+}
+
+// Cancels the build when a file is written, failing the write like an
+// interrupted one.
+type cancellingOutput struct {
+	MemoryOutput
+	ctx    context.Context
+	cancel context.CancelFunc
+	name   string
+}
+
+func (o *cancellingOutput) WriteFile(name string, data []byte) error {
+	if name == o.name {
+		o.cancel()
+		return o.ctx.Err()
+	}
+	return o.MemoryOutput.WriteFile(name, data)
+}
+
+func TestCancelledBuild(t *testing.T) {
+	input := fstest.MapFS{}
+	for _, name := range []string{"a", "b", "c"} {
+		input[name+".html"], input[name+".head"] = component(`<p>svelte-title--</p>`), component("")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	output := &cancellingOutput{ctx: ctx, cancel: cancel, name: "b/b.templ"}
+	err := Build(ctx, &BuildOptions{Input: input, Output: output, Jobs: 1})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want %v", err, context.Canceled)
+	}
+	// The `.go` file of the cancelled component is rolled back
+	assertFiles(t, &output.MemoryOutput, []string{"a/a.go", "a/a.templ"}, []string{"b/b.go", "c/c.go"})
+
+	cache, err := loadManifest(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cache.Components["a"] == nil {
+		t.Error("the built component is not in the manifest")
+	}
+	for _, name := range []string{"b", "c"} {
+		if entry := cache.Components[name]; entry != nil {
+			t.Errorf("%s is in the manifest: %+v", name, entry)
+		}
+	}
+
+	// The next build only builds what was left
+	var summary strings.Builder
+	err = Build(context.Background(), &BuildOptions{Input: input, Output: &output.MemoryOutput, Summary: &summary})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(summary.String(), "2 built, 1 cached") {
+		t.Errorf("unexpected summary:\n%s", summary.String())
+	}
+}
+
+func TestLongLine(t *testing.T) {
+	filler := strings.Repeat(`<span class="x">text</span>`, 4000)
+	input := fstest.MapFS{
+		"home.html": component(`<p>svelte-title--</p>` + filler + `<p>svelte-count{int}--</p>`),
+		"home.head": component(""),
+	}
+	if len(input["home.html"].Data) < 100_000 {
+		t.Fatal("the line is too short")
+	}
+	output := &MemoryOutput{}
+	if err := Build(context.Background(), &BuildOptions{Input: input, Output: output}); err != nil {
+		t.Fatal(err)
+	}
+	templSource := string(output.Files()["home/home.templ"])
+	for _, want := range []string{"{ props.Title }", "{ js.Stringify(props.Count) }"} {
+		if !strings.Contains(templSource, want) {
+			t.Errorf("templ source does not contain %q", want)
+		}
+	}
+
+	err := Build(context.Background(), &BuildOptions{Input: input, Output: &MemoryOutput{}, MaxInputSize: 64 << 10})
+	if err == nil || !strings.Contains(err.Error(), "home.html: file is larger than the limit of 65536 bytes") {
+		t.Errorf("expected a size limit error, got %v", err)
+	}
+}
+
+func TestMarkerErrorPosition(t *testing.T) {
+	src := &source{Path: "home.html", HTML: []byte(
+		`<p>svelte-items{[]}-label{int}--</p>` + "\n" +
+			`<ul class="iter-items[item]--"><li>svelte-items{[]}-label{int}-- svelte-i=index--</li></ul>` + "\n",
+	)}
+	_, html, err := parseHTMLFile(src, newMarkerSyntax(&BuildOptions{}), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		marker    string
+		line, col int
+	}{
+		{"svelte-items-label", 1, 4},
+		{"svelte-i", 2, 66},
+		{"iter-items[item]--", 2, 12},
+	}
+	for _, test := range tests {
+		err := markerError(src.Path, string(src.HTML), html.Markers, &parser.MarkerError{Marker: test.marker, Err: errors.New("failed")})
+		var fileErr *FileError
+		if !errors.As(err, &fileErr) || fileErr.Line != test.line || fileErr.Col != test.col {
+			t.Errorf("%s: got %v, want %d:%d", test.marker, err, test.line, test.col)
+		}
+	}
+}
+
+func TestConditionSyntax(t *testing.T) {
+	opts := &BuildOptions{IfPrefix: "when-", ElifPrefix: "orwhen-", ElseMarker: "otherwise--"}
+	goSource, templSource, err := Transform(
+		`<p class="when-admin--">A</p><p class="orwhen-guest--">B</p><p class="otherwise--">C</p><p class="if-x--">D</p>`,
+		nil, "home", opts,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"if props.Admin {", "} else if props.Guest {", "} else {", `<p class="if-x--">`} {
+		if !strings.Contains(templSource, want) {
+			t.Errorf("templ source does not contain %q:\n%s", want, templSource)
+		}
+	}
+	if strings.Contains(goSource, "X bool") {
+		t.Errorf("the default if prefix was read as a condition:\n%s", goSource)
+	}
+}
+
+func TestScopingHashWarning(t *testing.T) {
+	var warnings strings.Builder
+	goSource, templSource, err := Transform(`<p>svelte-step2--</p>`, nil, "home", &BuildOptions{Warnings: &warnings})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only a warning, the marker is still a prop
+	if !strings.Contains(goSource, "\tStep2 string `json:\"step2\"`\n") || !strings.Contains(templSource, "{ props.Step2 }") {
+		t.Errorf("svelte-step2-- is not a prop:\n%s\n%s", goSource, templSource)
+	}
+	if !strings.Contains(warnings.String(), "home.html:1:4: svelte-step2-- looks like the scoping class") {
+		t.Errorf("no warning for svelte-step2--: %q", warnings.String())
+	}
+}
+
+func TestLoopIndexIsScoped(t *testing.T) {
+	goSource, templSource, err := Transform(
+		`<ul class="iter-items[item,i]--"><li>svelte-i-- svelte-items{[]string}--</li></ul><p class="if-i--">svelte-i--</p>`+"\n"+
+			`<div><!--ssr:each items as item, n--><p>svelte-n--</p><!--ssr:end--></div><p>svelte-n{int}--</p>`,
+		nil, "home", nil,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"\tI string `json:\"i\"`\n", "\tN int `json:\"n\"`\n"} {
+		if !strings.Contains(goSource, want) {
+			t.Errorf("Go source does not contain %q:\n%s", want, goSource)
+		}
+	}
+	for _, want := range []string{
+		"for i, item := range props.Items {", "{ js.Stringify(i) }", "if props.I != \"\" {", "{ props.I }",
+		"for n := range props.Items {", "{ js.Stringify(n) }", "{ js.Stringify(props.N) }",
+	} {
+		if !strings.Contains(templSource, want) {
+			t.Errorf("templ source does not contain %q:\n%s", want, templSource)
+		}
+	}
+}
+
+// Go does not compile unused variables, so the loops only declare those used
+func TestUnusedLoopVariables(t *testing.T) {
+	_, templSource, err := Transform(
+		`<ul class="iter-items[item,i]--"><li>svelte-items{[]string}--</li></ul>`+
+			`<ul class="iter-items[item,i]--"><li>x</li></ul>`+
+			`<ul class="iter-scores[name-score]--"><li>svelte-scores{{string, int}}--</li></ul>`+
+			`<ul class="iter-scores[name-score]--"><li>y</li></ul>`+
+			`<ul class="iter-groups[group]--"><li><ul class="iter-users[user]--"><li>svelte-groups{[]}-users{[]}-name--</li></ul></li></ul>`+
+			`<ul class="iter-groups[group]--"><li><ul class="iter-users[user]--"><li>z</li></ul></li></ul>`,
+		nil, "home", nil,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"for range props.Items {", "for _, item := range props.Items {",
+		"for _, score := range js.Entries(props.Scores) {", "for range js.Entries(props.Scores) {",
+		// The nested loop iterates over a field of the value
+		"for _, group := range props.Groups {", "for range group.Users {",
+	} {
+		if !strings.Contains(templSource, want) {
+			t.Errorf("templ source does not contain %q:\n%s", want, templSource)
+		}
+	}
+}
+
+func TestMapKeyIsScoped(t *testing.T) {
+	goSource, templSource, err := Transform(
+		`<p>svelte-scores{{string, int}}--</p><ul class="iter-scores[name-score]--"><li>svelte-name--</li></ul><p>svelte-name--</p>`,
+		nil, "home", nil,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(goSource, "\tName string `json:\"name\"`\n") {
+		t.Errorf("Go source does not contain the name prop:\n%s", goSource)
+	}
+	for _, want := range []string{
+		"for name := range js.Entries(props.Scores) {", "{ name }", "{ props.Name }",
+		// Only the key order differs from the default config
+		"var homeJSON = json.Config{SortMapKeys: true}.Froze()", "homeJSON.Marshal(*props)",
+	} {
+		if !strings.Contains(templSource, want) {
+			t.Errorf("templ source does not contain %q:\n%s", want, templSource)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package builder
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+)
+
+// Version of the generator. It is recorded in the cache manifest, so that
+// components are rebuilt when the generated code changes.
+const Version = "0.9.0"
+
+// ManifestName is the file in the root of the output recording how every
+// component was built.
+const ManifestName = ".svelte-ssr-to-templ.json"
+
+type manifest struct {
+	Components map[string]*manifestEntry `json:"components"`
+}
+
+type manifestEntry struct {
+	InputHash  string `json:"inputHash"` // Hash of the `.html` and `.head` file
+	Options    string `json:"options"`
+	Version    string `json:"version"`
+	OutputHash string `json:"outputHash"` // Hash of the `.go` and `.templ` file
+}
+
+// Load the manifest of the output, which is empty when there is none yet.
+func loadManifest(output Output) (*manifest, error) {
+	m := &manifest{Components: make(map[string]*manifestEntry)}
+	data, err := output.ReadFile(ManifestName)
+	if errors.Is(err, fs.ErrNotExist) {
+		return m, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("invalid cache manifest %s: %w", ManifestName, err)
+	}
+	if m.Components == nil {
+		m.Components = make(map[string]*manifestEntry)
+	}
+	// The components are removed by name, which must stay in the output
+	for component := range m.Components {
+		if !fs.ValidPath(component) || component == "." {
+			return nil, fmt.Errorf("invalid cache manifest %s: invalid component %q", ManifestName, component)
+		}
+	}
+	return m, nil
+}
+
+func (m *manifest) save(output Output) error {
+	data, err := json.MarshalIndent(m, "", "\t")
+	if err != nil {
+		return err
+	}
+	return output.WriteFile(ManifestName, append(data, '\n'))
+}
+
+// The entry the component gets when it is built now, without the hash of
+// the outputs yet.
+func newManifestEntry(opts *BuildOptions, src *source) *manifestEntry {
+	return &manifestEntry{
+		InputHash: hashFiles(src.HTML, src.Head),
+		Options:   optionsKey(opts),
+		Version:   Version,
+	}
+}
+
+func hashFiles(files ...[]byte) string {
+	hash := sha256.New()
+	for _, data := range files {
+		// The length keeps the boundary between the files in the hash
+		fmt.Fprintf(hash, "%d\n", len(data))
+		hash.Write(data)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Whether the component can be skipped: it is built from the same input with
+// the same options and generator, and its outputs were neither deleted nor
+// edited since.
+func (e *manifestEntry) upToDate(cached *manifestEntry, output Output, component string) bool {
+	if cached == nil || e.InputHash != cached.InputHash || e.Options != cached.Options || e.Version != cached.Version {
+		return false
+	}
+	var files [][]byte
+	for _, name := range componentFiles(component) {
+		data, err := output.ReadFile(name)
+		if err != nil {
+			return false
+		}
+		files = append(files, data)
+	}
+	return cached.OutputHash == hashFiles(files...)
+}
+
+// The options affecting the generated code.
+func optionsKey(opts *BuildOptions) string {
+	return fmt.Sprintf(
+		"hash=%q time-format=%q json-naming=%q prop-prefix=%q loop-prefix=%q map-prefix=%q if-prefix=%q elif-prefix=%q else-marker=%q each-directive=%q end-directive=%q runtime-package=%q",
+		opts.Hash, opts.TimeFormat, opts.JSONNaming, opts.PropPrefix, opts.LoopPrefix, opts.MapPrefix,
+		opts.IfPrefix, opts.ElifPrefix, opts.ElseMarker, opts.EachDirective, opts.EndDirective, opts.RuntimePackage,
+	)
+}
+
+// Remove the outputs of the components in the manifest that are not one of
+// the components of the input.
+func pruneComponents(output Output, components []string) error {
+	cache, err := loadManifest(output)
+	if err != nil {
+		return err
+	}
+	orphans := orphanedComponents(cache, components)
+	if len(orphans) == 0 {
+		return nil
+	}
+	return removeComponents(output, orphans)
+}
+
+func orphanedComponents(cache *manifest, components []string) []string {
+	exists := make(map[string]bool, len(components))
+	for _, component := range components {
+		exists[component] = true
+	}
+	var orphans []string
+	for component := range cache.Components {
+		if !exists[component] {
+			orphans = append(orphans, component)
+		}
+	}
+	sort.Strings(orphans)
+	return orphans
+}
+
+// Remove the outputs of the components and forget them in the cache manifest.
+func removeComponents(output Output, components []string) error {
+	cache, err := loadManifest(output)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, component := range components {
+		errs = append(errs, removeComponentFiles(output, component))
+		delete(cache.Components, component)
+	}
+	errs = append(errs, cache.save(output))
+	return errors.Join(errs...)
+}
+
+// Remove the generated files of the component. The directory of the
+// component is only removed once it is empty, since it also holds the
+// outputs of the components nested in it, e.g. `routes/about/` in `routes/`.
+func removeComponentFiles(output Output, component string) error {
+	var errs []error
+	for _, name := range componentFiles(component) {
+		errs = append(errs, output.Remove(name))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,126 @@
+package builder
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func component(html string) *fstest.MapFile {
+	return &fstest.MapFile{Data: []byte(html + "\n")}
+}
+
+func nestedInput() fstest.MapFS {
+	return fstest.MapFS{
+		"routes.html":       component(`<p>svelte-title--</p>`),
+		"routes.head":       component(""),
+		"routes/about.html": component(`<p>svelte-name--</p>`),
+		"routes/about.head": component(""),
+	}
+}
+
+func assertFiles(t *testing.T, output *MemoryOutput, present []string, missing []string) {
+	t.Helper()
+	files := output.Files()
+	for _, name := range present {
+		if _, exists := files[name]; !exists {
+			t.Errorf("%s is missing", name)
+		}
+	}
+	for _, name := range missing {
+		if _, exists := files[name]; exists {
+			t.Errorf("%s was not removed", name)
+		}
+	}
+}
+
+func TestPruneKeepsNestedComponents(t *testing.T) {
+	input := nestedInput()
+	output := &MemoryOutput{}
+	opts := &BuildOptions{Input: input, Output: output}
+	if err := Build(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+
+	delete(input, "routes.html")
+	delete(input, "routes.head")
+	for range 2 {
+		if err := Build(context.Background(), opts); err != nil {
+			t.Fatal(err)
+		}
+		assertFiles(t, output,
+			[]string{"routes/about/about.go", "routes/about/about.templ"},
+			[]string{"routes/routes.go", "routes/routes.templ"},
+		)
+	}
+}
+
+func TestDirOutputPruneKeepsNestedComponents(t *testing.T) {
+	input := nestedInput()
+	dir := t.TempDir()
+	opts := &BuildOptions{Input: input, Output: DirOutput(dir)}
+	if err := Build(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+
+	delete(input, "routes/about.html")
+	delete(input, "routes/about.head")
+	if err := Build(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "routes", "routes.go")); err != nil {
+		t.Error(err)
+	}
+	// The directory of the pruned component is left empty
+	if _, err := os.Stat(filepath.Join(dir, "routes", "about")); !os.IsNotExist(err) {
+		t.Errorf("routes/about was not removed: %v", err)
+	}
+}
+
+func TestInvalidManifest(t *testing.T) {
+	for _, component := range []string{"../outside", "/etc", ".", "a/../b"} {
+		output := &MemoryOutput{}
+		output.WriteFile("a/a.go", []byte("keep"))
+		output.WriteFile(ManifestName, []byte(`{"components": {"`+component+`": {}}}`))
+		err := Build(context.Background(), &BuildOptions{Input: fstest.MapFS{}, Output: output})
+		if err == nil || !strings.Contains(err.Error(), "invalid component") {
+			t.Errorf("%s: expected an invalid component error, got %v", component, err)
+		}
+		assertFiles(t, output, []string{"a/a.go"}, nil)
+	}
+}
+
+func TestCacheChecksOutputs(t *testing.T) {
+	output := &MemoryOutput{}
+	var summary strings.Builder
+	opts := &BuildOptions{Input: nestedInput(), Output: output, Summary: &summary}
+	build := func() map[string][]byte {
+		t.Helper()
+		summary.Reset()
+		if err := Build(context.Background(), opts); err != nil {
+			t.Fatal(err)
+		}
+		return output.Files()
+	}
+	built := build()
+
+	build()
+	if !strings.Contains(summary.String(), "0 built, 2 cached") {
+		t.Errorf("unchanged components were rebuilt:\n%s", summary.String())
+	}
+
+	output.Remove("routes/about/about.go")
+	output.WriteFile("routes/routes.templ", []byte("edited"))
+	files := build()
+	if !strings.Contains(summary.String(), "2 built, 0 cached") {
+		t.Errorf("changed outputs were not rebuilt:\n%s", summary.String())
+	}
+	for _, name := range []string{"routes/about/about.go", "routes/routes.templ"} {
+		if string(files[name]) != string(built[name]) {
+			t.Errorf("%s was not restored", name)
+		}
+	}
+}
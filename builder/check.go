@@ -0,0 +1,149 @@
+package builder
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"path"
+	"sort"
+)
+
+type ChangeKind string
+
+const (
+	Created ChangeKind = "created"
+	Changed ChangeKind = "changed"
+	Deleted ChangeKind = "deleted"
+	// A generated file that no component produces, e.g. left behind by a
+	// component removed before the cache manifest existed. Builds keep it,
+	// since it is not in the manifest.
+	Stale ChangeKind = "stale"
+)
+
+// Change is a generated file that differs from the one in the output.
+type Change struct {
+	Name string // Slash separated path in the output
+	Kind ChangeKind
+	Old  []byte // nil when created
+	New  []byte // nil when deleted
+}
+
+// Check builds all components in memory and compares the generated files
+// with the output, returning the files a build would create, change or
+// delete. Components in the cache manifest without an input are deleted, and
+// other generated files without a component are stale, unless NoPrune is
+// set. The output is not modified.
+func Check(ctx context.Context, opts *BuildOptions) ([]*Change, error) {
+	opts, err := resolveOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	components, err := findComponents(opts.Input)
+	if err != nil {
+		return nil, err
+	}
+
+	generated := &MemoryOutput{}
+	inMemory := *opts
+	inMemory.Output = generated
+	inMemory.NoCache = true
+	inMemory.Summary = nil
+	if _, err := buildComponents(ctx, &inMemory, components); err != nil {
+		return nil, err
+	}
+
+	var changes []*Change
+	files := generated.Files()
+	delete(files, ManifestName)
+	for name, data := range files {
+		old, err := opts.Output.ReadFile(name)
+		if errors.Is(err, fs.ErrNotExist) {
+			changes = append(changes, &Change{Name: name, Kind: Created, New: data})
+		} else if err != nil {
+			return nil, err
+		} else if !bytes.Equal(old, data) {
+			changes = append(changes, &Change{Name: name, Kind: Changed, Old: old, New: data})
+		}
+	}
+
+	cache, err := loadManifest(opts.Output)
+	if err != nil {
+		return nil, err
+	}
+	var orphans []string
+	if !opts.NoPrune {
+		orphans = orphanedComponents(cache, components)
+	}
+	reported := make(map[string]bool)
+	for _, component := range orphans {
+		for _, name := range componentFiles(component) {
+			old, err := opts.Output.ReadFile(name)
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+			changes = append(changes, &Change{Name: name, Kind: Deleted, Old: old})
+			reported[name] = true
+		}
+	}
+
+	if !opts.NoPrune {
+		stale, err := staleFiles(opts.Output, files, reported)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, stale...)
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Name < changes[j].Name
+	})
+	return changes, nil
+}
+
+// Find the generated files in the output that are neither generated by a
+// component nor already reported.
+func staleFiles(output Output, generated map[string][]byte, reported map[string]bool) ([]*Change, error) {
+	names, err := output.ListFiles()
+	if err != nil {
+		return nil, err
+	}
+	var changes []*Change
+	for _, name := range names {
+		if _, exists := generated[name]; exists || reported[name] || !isComponentFile(name) {
+			continue
+		}
+		old, err := output.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		if bytes.HasPrefix(old, []byte(generatedHeader+"\n")) {
+			changes = append(changes, &Change{Name: name, Kind: Stale, Old: old})
+		}
+	}
+	return changes, nil
+}
+
+// Whether the name is one of the files generated for a component, e.g.
+// `pages/home/home.go`.
+func isComponentFile(name string) bool {
+	dir := path.Dir(name)
+	for _, filename := range componentFiles(dir) {
+		if filename == name {
+			return true
+		}
+	}
+	return false
+}
+
+// The files generated for a component, e.g. `pages/home/home.go` and
+// `pages/home/home.templ` for `pages/home`.
+func componentFiles(component string) []string {
+	name := path.Base(component)
+	return []string{
+		component + "/" + name + ".go",
+		component + "/" + name + ".templ",
+	}
+}
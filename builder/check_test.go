@@ -0,0 +1,39 @@
+package builder
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCheckReportsStaleFiles(t *testing.T) {
+	output := &MemoryOutput{}
+	opts := &BuildOptions{Input: nestedInput(), Output: output}
+	if err := Build(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	// Generated before the cache manifest existed, so it is not in it
+	output.WriteFile("old/old.templ", []byte(generatedHeader+"\npackage old\n"))
+	// Written by hand
+	output.WriteFile("notes/notes.go", []byte("package notes\n"))
+	output.WriteFile("routes/helpers.go", []byte(generatedHeader+"\npackage routes\n"))
+
+	changes, err := Check(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].Name != "old/old.templ" || changes[0].Kind != Stale {
+		for _, change := range changes {
+			t.Logf("%s %s", change.Kind, change.Name)
+		}
+		t.Fatalf("expected old/old.templ to be the only stale file, got %d changes", len(changes))
+	}
+
+	opts.NoPrune = true
+	changes, err = Check(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 {
+		t.Errorf("got %d changes with NoPrune", len(changes))
+	}
+}
@@ -0,0 +1,224 @@
+package builder
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The lines of context around the changes of a unified diff
+const diffContext = 3
+
+// The number of edits searched for before replacing the lines as a whole,
+// which bounds the time spent on files that changed completely
+const diffMaxEdits = 1000
+
+type diffLine struct {
+	op   byte // ' ', '-' or '+'
+	text string
+}
+
+// UnifiedDiff returns the unified diff of the old and new content of the
+// file, or an empty string if they are equal. A missing file is nil.
+func UnifiedDiff(name string, old []byte, new []byte) string {
+	oldLines, newLines := splitLines(string(old)), splitLines(string(new))
+	lines := diffLines(oldLines, newLines)
+
+	var diff strings.Builder
+	oldName, newName := "a/"+name, "b/"+name
+	if old == nil {
+		oldName = "/dev/null"
+	}
+	if new == nil {
+		newName = "/dev/null"
+	}
+
+	// Line numbers of the start of lines[i] in the old and new file
+	oldNumber, newNumber := 1, 1
+	for i := 0; i < len(lines); {
+		if lines[i].op == ' ' {
+			oldNumber++
+			newNumber++
+			i++
+			continue
+		}
+
+		// Extend the hunk until the changes are separated by more context than
+		// both sides of two hunks would show.
+		start := max(i-diffContext, 0)
+		end := i
+		for j := i; j < len(lines); j++ {
+			if lines[j].op != ' ' {
+				end = j + 1
+			} else if j-end >= 2*diffContext {
+				break
+			}
+		}
+		end = min(end+diffContext, len(lines))
+
+		hunkOld, hunkNew := oldNumber-(i-start), newNumber-(i-start)
+		oldCount, newCount := 0, 0
+		var hunk strings.Builder
+		for _, line := range lines[start:end] {
+			if line.op != '+' {
+				oldCount++
+			}
+			if line.op != '-' {
+				newCount++
+			}
+			hunk.WriteString(string(line.op) + line.text + "\n")
+		}
+
+		if diff.Len() == 0 {
+			fmt.Fprintf(&diff, "--- %s\n+++ %s\n", oldName, newName)
+		}
+		fmt.Fprintf(&diff, "@@ -%s +%s @@\n", hunkRange(hunkOld, oldCount), hunkRange(hunkNew, newCount))
+		diff.WriteString(hunk.String())
+
+		for _, line := range lines[i:end] {
+			if line.op != '+' {
+				oldNumber++
+			}
+			if line.op != '-' {
+				newNumber++
+			}
+		}
+		i = end
+	}
+	return diff.String()
+}
+
+// The range of a hunk, where an empty range starts at the line before it.
+func hunkRange(start int, count int) string {
+	if count == 0 {
+		start--
+	}
+	if count == 1 {
+		return fmt.Sprint(start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
+
+func splitLines(content string) []string {
+	if content == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+}
+
+// Diff the lines with the linear space variant of Myers' algorithm, which
+// keeps checking generated files of several megabytes cheap. The common
+// prefix and suffix are skipped first, since generated files usually change
+// in a few places.
+func diffLines(old []string, new []string) []diffLine {
+	return appendDiff(nil, old, new)
+}
+
+func appendDiff(lines []diffLine, a []string, b []string) []diffLine {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		lines = append(lines, diffLine{' ', a[prefix]})
+		prefix++
+	}
+	a, b = a[prefix:], b[prefix:]
+	suffix := 0
+	for suffix < len(a) && suffix < len(b) && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	common := a[len(a)-suffix:]
+	a, b = a[:len(a)-suffix], b[:len(b)-suffix]
+
+	if x, y := middleOfPath(a, b); x >= 0 {
+		lines = appendDiff(lines, a[:x], b[:y])
+		lines = appendDiff(lines, a[x:], b[y:])
+	} else {
+		for _, text := range a {
+			lines = append(lines, diffLine{'-', text})
+		}
+		for _, text := range b {
+			lines = append(lines, diffLine{'+', text})
+		}
+	}
+
+	for _, text := range common {
+		lines = append(lines, diffLine{' ', text})
+	}
+	return lines
+}
+
+// Find a point (x, y) on a shortest edit path from a to b splitting it in two
+// halves, by searching from both ends until the paths overlap. x is -1 when
+// there is nothing to split or the path is too long to search, a being
+// removed and b inserted as a whole.
+func middleOfPath(a []string, b []string) (int, int) {
+	n, m := len(a), len(b)
+	if n == 0 || m == 0 {
+		return -1, -1
+	}
+	maxD := (n + m + 1) / 2
+	offset := maxD
+	// forward[offset+k] is the furthest x on diagonal k = x-y reached from
+	// the start, backward the same from the end.
+	forward, backward := make([]int, 2*maxD+2), make([]int, 2*maxD+2)
+	for i := range forward {
+		forward[i], backward[i] = -1, -1
+	}
+	forward[offset+1], backward[offset+1] = 0, 0
+	delta := n - m
+	// The paths meet in the forward search if delta is odd
+	forwardMeets := delta%2 != 0
+	// Diagonals leaving the edit graph are skipped at the ends of the range
+	kStart, kEnd, k2Start, k2End := 0, 0, 0, 0
+	for d := range min(maxD, diffMaxEdits) {
+		for k := -d + kStart; k <= d-kEnd; k += 2 {
+			var x int
+			if k == -d || (k != d && forward[offset+k-1] < forward[offset+k+1]) {
+				x = forward[offset+k+1]
+			} else {
+				x = forward[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			forward[offset+k] = x
+			switch {
+			case x > n:
+				kEnd += 2
+			case y > m:
+				kStart += 2
+			case forwardMeets:
+				i := offset + delta - k
+				if i >= 0 && i < len(backward) && backward[i] != -1 && x >= n-backward[i] {
+					return x, y
+				}
+			}
+		}
+		for k := -d + k2Start; k <= d-k2End; k += 2 {
+			var x int
+			if k == -d || (k != d && backward[offset+k-1] < backward[offset+k+1]) {
+				x = backward[offset+k+1]
+			} else {
+				x = backward[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[n-x-1] == b[m-y-1] {
+				x++
+				y++
+			}
+			backward[offset+k] = x
+			switch {
+			case x > n:
+				k2End += 2
+			case y > m:
+				k2Start += 2
+			case !forwardMeets:
+				i := offset + delta - k
+				if i >= 0 && i < len(forward) && forward[i] != -1 && forward[i] >= n-x {
+					return forward[i], forward[i] - (delta - k)
+				}
+			}
+		}
+	}
+	return -1, -1
+}
@@ -0,0 +1,112 @@
+package builder
+
+import (
+	"fmt"
+	"math/rand"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	old := "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\nm\nn\no\n"
+	tests := []struct {
+		name     string
+		old, new []byte
+		want     string
+	}{
+		{"equal", []byte(old), []byte(old), ""},
+		{"created", nil, []byte("a\nb\n"), "--- /dev/null\n+++ b/home.go\n@@ -0,0 +1,2 @@\n+a\n+b\n"},
+		{"deleted", []byte("a\n"), nil, "--- a/home.go\n+++ /dev/null\n@@ -1 +0,0 @@\n-a\n"},
+		{
+			"changed",
+			[]byte(old),
+			[]byte(strings.Replace(strings.Replace(old, "b\n", "B\n", 1), "n\n", "", 1)),
+			"--- a/home.go\n+++ b/home.go\n" +
+				"@@ -1,5 +1,5 @@\n a\n-b\n+B\n c\n d\n e\n" +
+				"@@ -11,5 +11,4 @@\n k\n l\n m\n-n\n o\n",
+		},
+		{
+			// Changes closer than twice the context share a hunk
+			"merged",
+			[]byte(old),
+			[]byte(strings.Replace(strings.Replace(old, "b\n", "", 1), "h\n", "h\nH\n", 1)),
+			"--- a/home.go\n+++ b/home.go\n" +
+				"@@ -1,11 +1,11 @@\n a\n-b\n c\n d\n e\n f\n g\n h\n+H\n i\n j\n k\n",
+		},
+	}
+	for _, test := range tests {
+		if got := UnifiedDiff("home.go", test.old, test.new); got != test.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", test.name, got, test.want)
+		}
+	}
+}
+
+// The diff must turn the old lines into the new ones, with no more changes
+// than needed.
+func TestDiffLines(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	randomLines := func() []string {
+		lines := make([]string, random.Intn(30))
+		for i := range lines {
+			lines[i] = fmt.Sprint(random.Intn(4))
+		}
+		return lines
+	}
+	for range 500 {
+		old, new := randomLines(), randomLines()
+		var gotOld, gotNew []string
+		changes := 0
+		for _, line := range diffLines(old, new) {
+			if line.op != '+' {
+				gotOld = append(gotOld, line.text)
+			}
+			if line.op != '-' {
+				gotNew = append(gotNew, line.text)
+			}
+			if line.op != ' ' {
+				changes++
+			}
+		}
+		if !slices.Equal(gotOld, old) || !slices.Equal(gotNew, new) {
+			t.Fatalf("diff of %q and %q does not match them", old, new)
+		}
+		if want := len(old) + len(new) - 2*lcsLength(old, new); changes != want {
+			t.Fatalf("diff of %q and %q has %d changes, want %d", old, new, changes, want)
+		}
+	}
+}
+
+func lcsLength(a []string, b []string) int {
+	lengths := make([][]int, len(a)+1)
+	for i := range lengths {
+		lengths[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			} else {
+				lengths[i][j] = max(lengths[i+1][j], lengths[i][j+1])
+			}
+		}
+	}
+	return lengths[0][0]
+}
+
+// Changing a prop of a large component rewrites lines all over the file
+func BenchmarkUnifiedDiff(b *testing.B) {
+	var old, new strings.Builder
+	for i := range 20000 {
+		fmt.Fprintf(&old, "line %d\n", i)
+		if i%100 == 0 {
+			fmt.Fprintf(&new, "changed %d\n", i)
+		} else {
+			fmt.Fprintf(&new, "line %d\n", i)
+		}
+	}
+	b.ResetTimer()
+	for range b.N {
+		UnifiedDiff("large.go", []byte(old.String()), []byte(new.String()))
+	}
+}
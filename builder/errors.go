@@ -0,0 +1,54 @@
+package builder
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"svelte-ssr-to-templ/builder/parser"
+)
+
+// FileError is an error in one of the input files. Line and Col are 1-based
+// and zero when the position of the error is unknown.
+type FileError struct {
+	Path string
+	Line int
+	Col  int
+	Err  error
+}
+
+func (e *FileError) Error() string {
+	if e.Line == 0 {
+		return fmt.Sprintf("%s: %s", e.Path, e.Err)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", e.Path, e.Line, e.Col, e.Err)
+}
+
+func (e *FileError) Unwrap() error {
+	return e.Err
+}
+
+// Wrap an error of the parser, locating the marker that caused it in the
+// content of the file. The parser only sees the expressions of the prop
+// markers, their positions are looked up in markers.
+func markerError(path string, content string, markers map[string]position, err error) error {
+	fileErr := &FileError{Path: path, Err: err}
+	var markerErr *parser.MarkerError
+	if errors.As(err, &markerErr) {
+		if marker, exists := markers[markerErr.Marker]; exists {
+			fileErr.Line, fileErr.Col = marker.line, marker.col
+		} else {
+			fileErr.Line, fileErr.Col = findPosition(content, markerErr.Marker)
+		}
+	}
+	return fileErr
+}
+
+// The line and column of the first occurrence of text in the content.
+func findPosition(content string, text string) (int, int) {
+	index := strings.Index(content, text)
+	if index == -1 {
+		return 0, 0
+	}
+	lineStart := strings.LastIndex(content[:index], "\n") + 1
+	return strings.Count(content[:index], "\n") + 1, index - lineStart + 1
+}
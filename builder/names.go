@@ -0,0 +1,146 @@
+package builder
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"svelte-ssr-to-templ/builder/parser"
+	"unicode"
+)
+
+// Give every property an exported Go field name. Names that would collide
+// with a sibling are suffixed with a number, in order of the prop names.
+func assignGoNames(props map[string]*parser.Property) {
+	propNames := parser.SortedNames(props)
+
+	used := make(map[string]struct{}, len(props))
+	for _, name := range propNames {
+		prop := props[name]
+		goName := goIdentifier(name)
+		for i := 2; ; i++ {
+			if _, exists := used[goName]; !exists {
+				break
+			}
+			goName = goIdentifier(name) + strconv.Itoa(i)
+		}
+		used[goName] = struct{}{}
+		prop.GoName = goName
+		assignGoNames(prop.Children)
+	}
+}
+
+// Convert a prop name to an exported Go identifier, e.g. `user_id` to
+// `UserId`. Characters that are not valid in an identifier separate words.
+func goIdentifier(name string) string {
+	var identifier strings.Builder
+	upper := true
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		identifier.WriteRune(r)
+	}
+
+	// Exported identifiers can not start with a digit, and being upper case
+	// they never collide with Go's keywords.
+	result := identifier.String()
+	if result == "" || unicode.IsDigit([]rune(result)[0]) {
+		result = "X" + result
+	}
+	return result
+}
+
+// Derive the JSON key of every property without a key from its marker. The
+// keys of sibling properties must differ, encoders drop every field sharing a
+// key with another one.
+func assignJSONNames(props map[string]*parser.Property, naming string) error {
+	keys := make(map[string]string, len(props))
+	for _, name := range parser.SortedNames(props) {
+		prop := props[name]
+		if err := assignJSONNames(prop.Children, naming); err != nil {
+			return err
+		}
+		if prop.JSONName == "" {
+			if err := assignJSONName(prop, naming); err != nil {
+				return err
+			}
+		}
+		if other, exists := keys[prop.JSONName]; exists {
+			return fmt.Errorf("props %s and %s have the same JSON key %q", other, prop.Name, prop.JSONName)
+		}
+		keys[prop.JSONName] = prop.Name
+	}
+	return nil
+}
+
+func assignJSONName(prop *parser.Property, naming string) error {
+	switch naming {
+	case "", JSONNamingPreserve:
+		prop.JSONName = prop.Name
+	case JSONNamingCamel:
+		prop.JSONName = camelCase(prop.Name)
+	case JSONNamingSnake:
+		prop.JSONName = snakeCase(prop.Name)
+	case JSONNamingExplicit:
+		return fmt.Errorf("prop %s has no JSON key, add one with `%s=key`", prop.Name, prop.Name)
+	default:
+		return fmt.Errorf("invalid JSON naming strategy: %s", naming)
+	}
+	return nil
+}
+
+func camelCase(name string) string {
+	parts := words(name)
+	for i, word := range parts {
+		if i == 0 {
+			parts[i] = strings.ToLower(word)
+		} else {
+			parts[i] = strings.ToUpper(word[:1]) + strings.ToLower(word[1:])
+		}
+	}
+	return strings.Join(parts, "")
+}
+
+func snakeCase(name string) string {
+	parts := words(name)
+	for i, word := range parts {
+		parts[i] = strings.ToLower(word)
+	}
+	return strings.Join(parts, "_")
+}
+
+// Split a name into its words, at underscores and changes of case. A run of
+// upper case letters is a single word, e.g. `URLPath` is `URL` and `Path`.
+func words(name string) []string {
+	var parts []string
+	runes := []rune(name)
+	start := 0
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			if start < i {
+				parts = append(parts, string(runes[start:i]))
+			}
+			start = i + 1
+			continue
+		}
+		if i == start || !unicode.IsUpper(r) {
+			continue
+		}
+		prev := runes[i-1]
+		nextIsLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+		if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextIsLower) {
+			parts = append(parts, string(runes[start:i]))
+			start = i
+		}
+	}
+	if start < len(runes) {
+		parts = append(parts, string(runes[start:]))
+	}
+	return parts
+}
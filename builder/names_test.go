@@ -0,0 +1,131 @@
+package builder
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"svelte-ssr-to-templ/builder/parser"
+	"testing"
+)
+
+func TestWords(t *testing.T) {
+	tests := []struct {
+		name string
+		want []string
+	}{
+		{"URLPath", []string{"URL", "Path"}},
+		{"iOS", []string{"i", "OS"}},
+		{"user_id", []string{"user", "id"}},
+		{"userID", []string{"user", "ID"}},
+		{"page2Title", []string{"page2", "Title"}},
+		{"__name", []string{"name"}},
+	}
+	for _, test := range tests {
+		if got := words(test.name); !slices.Equal(got, test.want) {
+			t.Errorf("words(%q) = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestGoIdentifier(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"title", "Title"},
+		{"user-id", "UserId"},
+		{"first_name", "FirstName"},
+		{"userID", "UserID"},
+		{"1st", "X1st"},
+		{"-", "X"},
+		{"", "X"},
+		{"type", "Type"},
+	}
+	for _, test := range tests {
+		if got := goIdentifier(test.name); got != test.want {
+			t.Errorf("goIdentifier(%q) = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestAssignGoNames(t *testing.T) {
+	props := map[string]*parser.Property{
+		"userId":  {Name: "userId"},
+		"user_id": {Name: "user_id"},
+		"user-id": {Name: "user-id"},
+		"user": {Name: "user", Children: map[string]*parser.Property{
+			// Only siblings must differ
+			"user_id": {Name: "user_id"},
+		}},
+	}
+	assignGoNames(props)
+	// Numbered in the sorted order of the names
+	want := map[string]string{"user-id": "UserId", "userId": "UserId2", "user_id": "UserId3", "user": "User"}
+	for name, goName := range want {
+		if props[name].GoName != goName {
+			t.Errorf("%s: got %s, want %s", name, props[name].GoName, goName)
+		}
+	}
+	if got := props["user"].Children["user_id"].GoName; got != "UserId" {
+		t.Errorf("user-user_id: got %s, want UserId", got)
+	}
+
+	pair := map[string]*parser.Property{"user_id": {Name: "user_id"}, "userId": {Name: "userId"}}
+	assignGoNames(pair)
+	if pair["userId"].GoName != "UserId" || pair["user_id"].GoName != "UserId2" {
+		t.Errorf("userId and user_id: got %s and %s, want UserId and UserId2", pair["userId"].GoName, pair["user_id"].GoName)
+	}
+}
+
+func TestJSONNaming(t *testing.T) {
+	tests := []struct {
+		naming string
+		html   string
+		want   []string
+	}{
+		{JSONNamingPreserve, `<p>svelte-user_name-- svelte-URLPath--</p>`, []string{`json:"user_name"`, `json:"URLPath"`}},
+		{JSONNamingCamel, `<p>svelte-user_name-- svelte-URLPath--</p>`, []string{`json:"userName"`, `json:"urlPath"`}},
+		{JSONNamingSnake, `<p>svelte-userName-- svelte-URLPath--</p>`, []string{`json:"user_name"`, `json:"url_path"`}},
+		{JSONNamingExplicit, `<p>svelte-userName=uname--</p>`, []string{`json:"uname"`}},
+		// A key in the marker takes precedence over the strategy
+		{JSONNamingCamel, `<p>svelte-user_name=name-- svelte-user-first_name=first--</p>`, []string{`json:"name"`, `json:"first"`, `json:"user"`}},
+	}
+	for _, test := range tests {
+		goSource, _, err := Transform(test.html, nil, "home", &BuildOptions{JSONNaming: test.naming})
+		if err != nil {
+			t.Errorf("%s %s: %v", test.naming, test.html, err)
+			continue
+		}
+		for _, want := range test.want {
+			if !strings.Contains(goSource, want) {
+				t.Errorf("%s %s: Go source does not contain %s:\n%s", test.naming, test.html, want, goSource)
+			}
+		}
+	}
+}
+
+func TestJSONNamingErrors(t *testing.T) {
+	tests := []struct {
+		naming string
+		html   string
+		want   string
+	}{
+		{JSONNamingExplicit, `<p>svelte-title--</p>`, "prop title has no JSON key, add one with `title=key`"},
+		{JSONNamingCamel, `<p>svelte-URL-- svelte-url--</p>`, `props URL and url have the same JSON key "url"`},
+		{JSONNamingPreserve, `<p>svelte-name-- svelte-title=name--</p>`, `props name and title have the same JSON key "name"`},
+		// Only siblings must differ
+		{JSONNamingSnake, `<p>svelte-user-userName-- svelte-user-user_name--</p>`, `props userName and user_name have the same JSON key "user_name"`},
+	}
+	for _, test := range tests {
+		_, _, err := Transform(test.html, nil, "home", &BuildOptions{JSONNaming: test.naming})
+		var fileErr *FileError
+		if !errors.As(err, &fileErr) || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s %s: got %v, want a FileError containing %q", test.naming, test.html, err, test.want)
+		}
+	}
+
+	_, _, err := Transform(`<p>svelte-name-- svelte-user-name--</p>`, nil, "home", &BuildOptions{JSONNaming: JSONNamingSnake})
+	if err != nil {
+		t.Errorf("the same key at different levels failed: %v", err)
+	}
+}
@@ -0,0 +1,154 @@
+package builder
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// Output receives the generated files. Names are slash separated and relative
+// to the root of the output, e.g. `pages/home/home.templ`.
+type Output interface {
+	// Read a file written before, failing with fs.ErrNotExist when there is
+	// none. Used to read the cache manifest.
+	ReadFile(name string) ([]byte, error)
+	WriteFile(name string, data []byte) error
+	// Remove the file and the parent directories it leaves empty. A missing
+	// file is no error.
+	Remove(name string) error
+	// List the names of all files in the output, in order
+	ListFiles() ([]string, error)
+}
+
+// DirOutput writes the generated files to a directory on disk.
+type DirOutput string
+
+func (dir DirOutput) ReadFile(name string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(string(dir), filepath.FromSlash(name)))
+}
+
+// WriteFile writes to a temporary file renamed over the file, so that an
+// interrupted build never leaves a partially written file behind.
+func (dir DirOutput) WriteFile(name string, data []byte) error {
+	filename := filepath.Join(string(dir), filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(filename), os.ModePerm); err != nil {
+		return err
+	}
+	temp, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+
+	if _, err := temp.Write(data); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Chmod(0644); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), filename)
+}
+
+// Remove also removes the parent directories left empty, up to the output
+// directory itself.
+func (dir DirOutput) Remove(name string) error {
+	err := os.Remove(filepath.Join(string(dir), filepath.FromSlash(name)))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	for parent := path.Dir(path.Clean(name)); parent != "." && parent != "/"; parent = path.Dir(parent) {
+		// Fails for directories that are not empty, which is fine
+		if os.Remove(filepath.Join(string(dir), filepath.FromSlash(parent))) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+// ListFiles lists nothing when the directory does not exist yet.
+func (dir DirOutput) ListFiles() ([]string, error) {
+	var names []string
+	err := filepath.WalkDir(string(dir), func(filename string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.IsDir() {
+			name, err := filepath.Rel(string(dir), filename)
+			if err != nil {
+				return err
+			}
+			names = append(names, filepath.ToSlash(name))
+		}
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	return names, err
+}
+
+// MemoryOutput keeps the generated files in memory. The zero value is ready
+// to use and safe for concurrent builds.
+type MemoryOutput struct {
+	mutex sync.Mutex
+	files map[string][]byte
+}
+
+func (m *MemoryOutput) ReadFile(name string) ([]byte, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	data, exists := m.files[path.Clean(name)]
+	if !exists {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: fs.ErrNotExist}
+	}
+	return append([]byte{}, data...), nil
+}
+
+func (m *MemoryOutput) WriteFile(name string, data []byte) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.files == nil {
+		m.files = make(map[string][]byte)
+	}
+	m.files[path.Clean(name)] = append([]byte{}, data...)
+	return nil
+}
+
+// Remove removes the file, directories only exist through their files.
+func (m *MemoryOutput) Remove(name string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.files, path.Clean(name))
+	return nil
+}
+
+func (m *MemoryOutput) ListFiles() ([]string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	names := make([]string, 0, len(m.files))
+	for name := range m.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Files returns a copy of the files written so far, by name.
+func (m *MemoryOutput) Files() map[string][]byte {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	files := make(map[string][]byte, len(m.files))
+	for name, data := range m.files {
+		files[name] = data
+	}
+	return files
+}
@@ -2,58 +2,187 @@ package parser
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"regexp"
+	"sort"
 	"strings"
 	"svelte-ssr-to-templ/builder/types"
 
 	"golang.org/x/net/html"
 )
 
-var loopRegex = regexp.MustCompile(`iter-(([a-zA-Z\[\]-]+)-)*([a-zA-Z]+)\[([a-zA-Z]+)\]--`)
-var mapRegex = regexp.MustCompile(`iter-(([a-zA-Z\[\]-]+)-)*([a-zA-Z]+)\[([a-zA-Z]+)-([a-zA-Z]+)\]--`)
+// The default prefixes of the markers. Loops and maps both use `iter-`, the
+// markers differ in the variables between the brackets.
+const (
+	DefaultPropPrefix = "svelte-"
+	DefaultLoopPrefix = "iter-"
+	DefaultMapPrefix  = "iter-"
+	DefaultIfPrefix   = "if-"
+	DefaultElifPrefix = "elif-"
+	DefaultElseMarker = "else--"
+	// The directives in HTML comments, `<!--ssr:each items as item-->` and
+	// `<!--ssr:end-->`
+	DefaultEachDirective = "ssr:each"
+	DefaultEndDirective  = "ssr:end"
+)
+
+// Syntax matches the markers starting with the configured prefixes. The prop
+// markers are replaced before parsing, their prefix is only used to locate
+// errors.
+type Syntax struct {
+	PropPrefix string
+	LoopPrefix string
+	MapPrefix  string
+	IfPrefix   string
+	ElifPrefix string
+	ElseMarker string // The whole class, unlike the prefixes
+	// The keywords of the directives in HTML comments
+	EachDirective string
+	EndDirective  string
+	loopRegex     *regexp.Regexp
+	mapRegex      *regexp.Regexp
+	ifRegex       *regexp.Regexp
+	elifRegex     *regexp.Regexp
+	eachRegex     *regexp.Regexp
+	endRegex      *regexp.Regexp
+}
+
+// NewSyntax returns the syntax of the markers, using the default for every
+// empty field of markers.
+func NewSyntax(markers Syntax) *Syntax {
+	s := &Syntax{
+		PropPrefix:    defaultString(markers.PropPrefix, DefaultPropPrefix),
+		LoopPrefix:    defaultString(markers.LoopPrefix, DefaultLoopPrefix),
+		MapPrefix:     defaultString(markers.MapPrefix, DefaultMapPrefix),
+		IfPrefix:      defaultString(markers.IfPrefix, DefaultIfPrefix),
+		ElifPrefix:    defaultString(markers.ElifPrefix, DefaultElifPrefix),
+		ElseMarker:    defaultString(markers.ElseMarker, DefaultElseMarker),
+		EachDirective: defaultString(markers.EachDirective, DefaultEachDirective),
+		EndDirective:  defaultString(markers.EndDirective, DefaultEndDirective),
+	}
+	s.loopRegex = regexp.MustCompile(regexp.QuoteMeta(s.LoopPrefix) + `(([a-zA-Z\[\],-]+)-)*([a-zA-Z]+)\[([a-zA-Z]+)(?:,([a-zA-Z]+))?\]--`)
+	s.mapRegex = regexp.MustCompile(regexp.QuoteMeta(s.MapPrefix) + `(([a-zA-Z\[\],-]+)-)*([a-zA-Z]+)\[([a-zA-Z]+)-([a-zA-Z]+)\]--`)
+	s.ifRegex = regexp.MustCompile(`^` + regexp.QuoteMeta(s.IfPrefix) + conditionPathPattern + `--$`)
+	s.elifRegex = regexp.MustCompile(`^` + regexp.QuoteMeta(s.ElifPrefix) + conditionPathPattern + `--$`)
+	s.eachRegex = regexp.MustCompile(`<!--\s*` + regexp.QuoteMeta(s.EachDirective) +
+		`\s+([a-zA-Z0-9_]+(?:-[a-zA-Z0-9_]+)*)\s+as\s+([a-zA-Z]+)(?:\s*,\s*([a-zA-Z]+))?\s*-->`)
+	s.endRegex = regexp.MustCompile(`^\s*` + regexp.QuoteMeta(s.EndDirective) + `\s*$`)
+	return s
+}
+
+func defaultString(value string, defaultValue string) string {
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
+
+// The property path of a condition marker
+const conditionPathPattern = `([a-zA-Z0-9_]+(-[a-zA-Z0-9_]+)*)`
+
+var defaultSyntax = NewSyntax(Syntax{})
+var prefixRegex = regexp.MustCompile(`([a-zA-Z]+)(\[[a-zA-Z,-]*\])?`)
+
+var expressionRegex = regexp.MustCompile(`{ props\.([a-zA-Z0-9_.]+) }`)
 
 type Context struct {
 	PropName    string
+	Path        []string
+	Prop        *Property
 	LoopContext *LoopContext
 	MapContext  *MapContext
 	PrevContext *Context
+
+	loop      *html.Node // The `for` node of the loop
+	valueUsed bool
 }
 
 type LoopContext struct {
-	IndexName string
+	IndexName string // Name of the value, for historical reasons
+	Index     string // Name of the index variable, if the marker gives one
+
+	indexUsed bool
 }
 
 type MapContext struct {
 	KeyName string
 	ValName string
+
+	keyUsed bool
 }
 
 type Property struct {
-	Name     string
-	Type     string
+	Name     string // Name of the Svelte prop
+	GoName   string // Exported name of the Go field
+	JSONName string // Key in the JSON props
+	Type     *types.Type
 	Children map[string]*Property
 }
 
-type PropertyWithContext struct {
-	currentProp *Property
-	context     *Context
+type Options struct {
+	TimeFormat     string  // Go layout used to render time.Time values
+	Syntax         *Syntax // Defaults to the default prefixes
+	RuntimePackage string  // Import path of the js package, defaults to types.RuntimePackage
+}
+
+func (opts *Options) conversion() *types.Conversion {
+	return &types.Conversion{TimeFormat: opts.TimeFormat, RuntimePackage: opts.RuntimePackage}
+}
+
+func (opts *Options) syntax() *Syntax {
+	if opts.Syntax == nil {
+		return defaultSyntax
+	}
+	return opts.Syntax
+}
+
+// LoopMarker is a parsed `iter-[prefix-]propName[val]--`,
+// `iter-[prefix-]propName[val,index]--` or `iter-[prefix-]propName[key-val]--`
+// class.
+type LoopMarker struct {
+	Text     string // The marker as written in the class
+	Prefix   []string
+	PropName string
+	ValName  string
+	KeyName  string // Only set for maps, `iter-m[key-val]--`
+	// The index of a list or the key of a map, `iter-items[item,i]--` or
+	// `<!--ssr:each items as item, i-->`
+	Index string
+}
+
+// MarkerError is an error caused by a marker, which is used to find its
+// position in the source file.
+type MarkerError struct {
+	Marker string
+	Err    error
+}
+
+func (e *MarkerError) Error() string {
+	return e.Marker + ": " + e.Err.Error()
+}
+
+func (e *MarkerError) Unwrap() error {
+	return e.Err
 }
 
+var errPropNotFound = errors.New("could not find prop")
+
 func Parse(
 	props map[string]*Property,
 	htmlInput *strings.Reader,
 	buffer *bufio.Writer,
-) {
+	opts *Options,
+) ([]string, error) {
 	scaffold, err := html.Parse(&strings.Reader{})
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 	body := scaffold.FirstChild.FirstChild.NextSibling
 
 	doc, err := html.ParseFragment(htmlInput, body)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	context := &Context{}
@@ -64,8 +193,12 @@ func Parse(
 		body.AppendChild(node)
 	}
 
-	modifyHTML(body, &modifyHTMLArgs{props, context})
+	conversion := opts.conversion()
+	if err := modifyHTML(body, &modifyHTMLArgs{props, context, conversion, opts.syntax()}); err != nil {
+		return nil, err
+	}
 	recursiveMap(body, printHtml, &printHtmlArgs{2, buffer})
+	return conversion.Imports(), nil
 }
 
 type printHtmlArgs struct {
@@ -87,13 +220,17 @@ func printHtml(n *html.Node, args *printHtmlArgs) {
 		return
 	}
 
-	if strings.HasPrefix(n.Data, "for") {
+	if isControlNode(n) {
 		buf.WriteString(indent + n.Data + "\n")
 	} else {
 		buf.WriteString(indent + "<" + n.Data)
 		if n.Attr != nil {
 			for _, attr := range n.Attr {
-				buf.WriteString(" " + attr.Key + `="` + attr.Val + `"`)
+				if isExpression(attr.Val) {
+					buf.WriteString(" " + attr.Key + "=" + attr.Val)
+				} else {
+					buf.WriteString(" " + attr.Key + `="` + attr.Val + `"`)
+				}
 
 				// If the attribute is an id, then we need to replace the prop name
 				// with the loop index
@@ -106,279 +243,665 @@ func printHtml(n *html.Node, args *printHtmlArgs) {
 	}
 	recursiveMap(n, printHtml, &printHtmlArgs{depth + 1, buf})
 
-	// If node starts with `for`
-	if strings.HasPrefix(n.Data, "for") {
-		buf.WriteString(indent + "}\n")
+	// If node is a `for` or `if` block. An `else` branch closes the block
+	// itself.
+	if isControlNode(n) {
+		if !isElseNode(nextSibling(n)) {
+			buf.WriteString(indent + "}\n")
+		}
 	} else {
 		buf.WriteString(indent + "</" + n.Data + ">\n")
 	}
 }
 
-// Recursively search for the property
-func findProps(props map[string]*Property, name string) *Property {
-	for _, prop := range props {
-		if prop.Name == name {
-			return prop
+// Attribute values made up of a single templ expression, e.g. `{ props.href }`
+func isExpression(val string) bool {
+	if !strings.HasPrefix(val, "{ ") || !strings.HasSuffix(val, " }") {
+		return false
+	}
+	// The first brace must only be closed by the last one
+	depth := 0
+	for i, c := range val {
+		if c == '{' {
+			depth++
+		} else if c == '}' {
+			depth--
+			if depth == 0 {
+				return i == len(val)-1
+			}
+		}
+	}
+	return false
+}
+
+// Control nodes are the `for`, `if` and `else` blocks inserted by modifyHTML.
+// Their Data always contains a space, which no element name can.
+func isControlNode(n *html.Node) bool {
+	return n.Type == html.ElementNode &&
+		(strings.HasPrefix(n.Data, "for ") ||
+			strings.HasPrefix(n.Data, "if ") ||
+			isElseNode(n))
+}
+
+func isElseNode(n *html.Node) bool {
+	return n != nil && n.Type == html.ElementNode && strings.HasPrefix(n.Data, "} else ")
+}
+
+// The next sibling, skipping over whitespace only text.
+func nextSibling(n *html.Node) *html.Node {
+	for c := n.NextSibling; c != nil; c = c.NextSibling {
+		if c.Type != html.TextNode || strings.TrimSpace(c.Data) != "" {
+			return c
+		}
+	}
+	return nil
+}
+
+// The previous sibling, removing whitespace only text along the way.
+func prevSibling(n *html.Node) *html.Node {
+	for c := n.PrevSibling; c != nil; c = n.PrevSibling {
+		if c.Type != html.TextNode || strings.TrimSpace(c.Data) != "" {
+			return c
+		}
+		n.Parent.RemoveChild(c)
+	}
+	return nil
+}
+
+// Search for the property along a dash separated path, e.g. `user-name`
+func lookupProp(props map[string]*Property, path []string) *Property {
+	var prop *Property
+	for _, part := range path {
+		if props == nil {
+			return nil
+		}
+		prop = props[part]
+		if prop == nil {
+			return nil
+		}
+		props = prop.Children
+	}
+	return prop
+}
+
+// SortedNames returns the names of the properties in order, so that the
+// generated code does not depend on the iteration order of the map.
+func SortedNames(props map[string]*Property) []string {
+	names := make([]string, 0, len(props))
+	for name := range props {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Search for the property breadth first, returning its path. Shallower
+// properties and then the first by name take precedence.
+func findPropPath(props map[string]*Property, name string) []string {
+	type level struct {
+		props map[string]*Property
+		path  []string
+	}
+	queue := []level{{props, nil}}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		if _, exists := current.props[name]; exists {
+			return append(current.path, name)
 		}
-		if prop.Children != nil {
-			return findProps(prop.Children, name)
+		for _, childName := range SortedNames(current.props) {
+			if children := current.props[childName].Children; len(children) > 0 {
+				path := append(append([]string{}, current.path...), childName)
+				queue = append(queue, level{children, path})
+			}
 		}
 	}
-	panic("Could not find prop")
+	return nil
+}
+
+// FindLoopMarkers returns all the loop and map markers in the text.
+func (s *Syntax) FindLoopMarkers(text string) []*LoopMarker {
+	var markers []*LoopMarker
+	for _, result := range s.loopRegex.FindAllStringSubmatch(text, -1) {
+		markers = append(markers, &LoopMarker{
+			Text:     result[0],
+			Prefix:   parsePrefix(result[2]),
+			PropName: result[3],
+			ValName:  result[4],
+			Index:    result[5],
+		})
+	}
+	for _, result := range s.mapRegex.FindAllStringSubmatch(text, -1) {
+		markers = append(markers, &LoopMarker{
+			Text:     result[0],
+			Prefix:   parsePrefix(result[2]),
+			PropName: result[3],
+			KeyName:  result[4],
+			ValName:  result[5],
+		})
+	}
+	return markers
+}
+
+// Split the prefix of a nested loop marker, e.g. `items[item]-tags`, into its
+// property path.
+func parsePrefix(prefix string) []string {
+	var path []string
+	for _, result := range prefixRegex.FindAllStringSubmatch(prefix, -1) {
+		path = append(path, result[1])
+	}
+	return path
+}
+
+// LoopPath returns the path of the property iterated over by a top level
+// marker. Without a prefix, the property is searched for by name.
+func LoopPath(props map[string]*Property, marker *LoopMarker) []string {
+	if len(marker.Prefix) > 0 {
+		return append(append([]string{}, marker.Prefix...), marker.PropName)
+	}
+	if path := findPropPath(props, marker.PropName); path != nil {
+		return path
+	}
+	return []string{marker.PropName}
 }
 
 type modifyHTMLArgs struct {
-	props   map[string]*Property
-	context *Context
+	props      map[string]*Property
+	context    *Context
+	conversion *types.Conversion
+	syntax     *Syntax
 }
 
 func modifyHTML(
 	node *html.Node,
 	args *modifyHTMLArgs,
-) {
-	// If the node has a class called `iter-[propName]--` then we need to
-	// replace the single child node with a loop.
+) error {
+	// If the node has a class called `if-[propName]--`, `elif-[propName]--` or
+	// `else--` then we need to wrap the node in an if block.
 	if node.Type == html.ElementNode {
-		for _, attr := range node.Attr {
-			if attr.Key != "class" {
-				continue
+		if keyword, path, found := args.syntax.findCondition(node); found {
+			condition, err := createConditionNode(node, keyword, path, args)
+			if err != nil {
+				return err
 			}
+			wrapNode(node, condition)
+		}
+	}
 
-			result := loopRegex.FindStringSubmatch(attr.Val)
-			if result != nil {
-				// Extract the index name from the class
-				propName := result[3]
-				indexName := result[4]
-
-				prevContext := setPrevContext(args.context)
-				args.context = &Context{
-					PropName:    propName,
-					LoopContext: &LoopContext{IndexName: indexName},
-					PrevContext: prevContext,
-				}
+	if err := replaceExpressions(node, args); err != nil {
+		return err
+	}
 
-				currentProp := findProps(args.props, propName)
-				recursiveMap(
-					node, replaceNodeWithLoop, &PropertyWithContext{
-						currentProp, args.context,
-					},
-				)
-				break
+	// If the node has a class called `iter-[propName]--` then we need to
+	// replace the children of the node with a loop.
+	if node.Type == html.ElementNode {
+		if marker := findLoopMarker(node, args.syntax); marker != nil {
+			context, err := loopContext(marker, args)
+			if err != nil {
+				return err
 			}
-
-			result = mapRegex.FindStringSubmatch(attr.Val)
-			if result != nil {
-				// Extract the key and value results from the class
-				propName := result[3]
-				keyName := result[4]
-				valName := result[5]
-
-				prevContext := setPrevContext(args.context)
-				args.context = &Context{
-					PropName:    propName,
-					MapContext:  &MapContext{KeyName: keyName, ValName: valName},
-					PrevContext: prevContext,
-				}
-
-				currentProp := findProps(args.props, propName)
-				recursiveMap(
-					node, replaceNodeWithMap, &PropertyWithContext{
-						currentProp, args.context,
-					},
-				)
-				break
+			swapNodeChildren(node, createNode(args, context))
+			stripMarkerClasses(node, args.syntax)
+			err = modifyChildren(context.loop, &modifyHTMLArgs{args.props, context, args.conversion, args.syntax})
+			if err != nil {
+				return err
 			}
+			writeLoopHeader(args, context)
+			return nil
 		}
+		stripMarkerClasses(node, args.syntax)
 	}
 
-	if args.context.PrevContext != nil {
-		args.context = args.context.PrevContext
-	}
-
-	recursiveMap(node, modifyHTML, args)
+	return modifyChildren(node, args)
 }
 
-func replaceNodeWithLoop(
-	node *html.Node,
-	args *PropertyWithContext,
-) {
-	if node.Type == html.CommentNode {
+// Remove the loop, map and condition markers from the class of the node, they
+// are only needed at build time. An empty class attribute is removed.
+func stripMarkerClasses(node *html.Node, syntax *Syntax) {
+	for i, attr := range node.Attr {
+		if attr.Key != "class" || isExpression(attr.Val) {
+			continue
+		}
+		var classes []string
+		for _, class := range strings.Fields(attr.Val) {
+			if !syntax.isMarker(class) {
+				classes = append(classes, class)
+			}
+		}
+		if len(classes) == 0 {
+			node.Attr = append(node.Attr[:i], node.Attr[i+1:]...)
+		} else {
+			node.Attr[i].Val = strings.Join(classes, " ")
+		}
 		return
 	}
-	parent := node.Parent
-	recursiveMap(node, replaceListProp, args)
-	swapNodeChildren(parent, createNode(args.context))
 }
 
-func swapNodeChildren(parent *html.Node, node *html.Node) {
-	for c := parent.FirstChild; c != nil; c = parent.FirstChild {
-		parent.RemoveChild(c)
-		node.AppendChild(c)
+func (s *Syntax) isMarker(class string) bool {
+	return s.loopRegex.FindString(class) == class ||
+		s.mapRegex.FindString(class) == class ||
+		s.ifRegex.MatchString(class) ||
+		s.elifRegex.MatchString(class) ||
+		class == s.ElseMarker
+}
+
+// Not using recursiveMap, since wrapping a child in an if block detaches it
+// from its siblings.
+func modifyChildren(node *html.Node, args *modifyHTMLArgs) error {
+	for c := node.FirstChild; c != nil; {
+		// A `<!--ssr:each-->` directive moves the siblings up to its
+		// `<!--ssr:end-->` into a loop, which is modified in its own context.
+		if marker := args.syntax.findDirective(c); marker != nil {
+			loop, context, err := expandDirective(c, marker, args)
+			if err != nil {
+				return err
+			}
+			err = modifyChildren(loop, &modifyHTMLArgs{args.props, context, args.conversion, args.syntax})
+			if err != nil {
+				return err
+			}
+			writeLoopHeader(args, context)
+			c = loop.NextSibling
+			continue
+		}
+
+		next := c.NextSibling
+		if err := modifyHTML(c, args); err != nil {
+			return err
+		}
+		c = next
 	}
-	parent.AppendChild(node)
+	return nil
 }
 
-func replaceListProp(
-	node *html.Node,
-	args *PropertyWithContext,
-) {
-	recursiveMap(node, replaceListProp, args)
-	currentProp := args.currentProp
-	context := args.context
+// Create the context of the loop of a marker, nested in the current context.
+func loopContext(marker *LoopMarker, args *modifyHTMLArgs) (*Context, error) {
+	var path []string
+	if args.context.Prop != nil && len(marker.Prefix) == 0 {
+		path = append(append([]string{}, args.context.Path...), marker.PropName)
+	} else {
+		path = LoopPath(args.props, marker)
+	}
 
-	propName := context.PropName
+	context := &Context{
+		PropName:    marker.PropName,
+		Path:        path,
+		Prop:        lookupProp(args.props, path),
+		PrevContext: args.context,
+	}
+	if context.Prop == nil {
+		return nil, &MarkerError{marker.Text, errPropNotFound}
+	}
+	switch {
+	case context.Prop.Type != nil && context.Prop.Type.Kind == types.Map:
+		keyName := marker.KeyName
+		if keyName == "" {
+			keyName = marker.Index
+		}
+		context.MapContext = &MapContext{KeyName: keyName, ValName: marker.ValName}
+	case marker.KeyName != "":
+		return nil, &MarkerError{marker.Text, errors.New("the prop is not a map, give its type, e.g. {{string,string}}")}
+	default:
+		context.LoopContext = &LoopContext{IndexName: marker.ValName, Index: marker.Index}
+	}
+	return context, nil
+}
 
-	index := getPropIndex(node, propName)
-	currentLine := context.LoopContext.IndexName + node.Data[index+len(propName)+1:]
-	finalDotIndex := strings.LastIndex(currentLine, ".")
-	if finalDotIndex == -1 {
-		modifyNodeDataOther(currentLine, currentProp, types.ListTypeToStringFunc, node)
+// Replace the `<!--ssr:each-->` comment and its `<!--ssr:end-->` with a loop
+// over the siblings between them. The loop is returned with its context, its
+// children are still to be modified.
+func expandDirective(start *html.Node, marker *LoopMarker, args *modifyHTMLArgs) (*html.Node, *Context, error) {
+	// The path of a directive may start with the variable of an outer loop
+	if len(marker.Prefix) > 0 {
+		for c := args.context; c.Prop != nil; c = c.PrevContext {
+			if c.valueName() == marker.Prefix[0] {
+				marker.Prefix = append(append([]string{}, c.Path...), marker.Prefix[1:]...)
+				break
+			}
+		}
+	}
 
-	} else {
-		field := currentLine[finalDotIndex+1:]
-		field = field[:findFinal(field)]
+	// Find the matching end, skipping over nested directives
+	depth := 0
+	var end *html.Node
+	for c := start.NextSibling; c != nil && end == nil; c = c.NextSibling {
+		if args.syntax.findDirective(c) != nil {
+			depth++
+		} else if args.syntax.isEndDirective(c) {
+			if depth == 0 {
+				end = c
+			}
+			depth--
+		}
+	}
+	if end == nil {
+		return nil, nil, &MarkerError{marker.Text, fmt.Errorf("missing <!--%s-->", args.syntax.EndDirective)}
+	}
 
-		prop := findProps(currentProp.Children, field)
+	context, err := loopContext(marker, args)
+	if err != nil {
+		return nil, nil, err
+	}
+	loop := createNode(args, context)
+	start.Parent.InsertBefore(loop, start)
+	for c := start.NextSibling; c != end; c = start.NextSibling {
+		start.Parent.RemoveChild(c)
+		loop.AppendChild(c)
+	}
+	start.Parent.RemoveChild(start)
+	end.Parent.RemoveChild(end)
+	return loop, context, nil
+}
 
-		modifyNodeData(currentLine, prop, types.ListTypeToStringFunc, node)
+// The `<!--ssr:each path as val-->` or `<!--ssr:each path as val, index-->`
+// directive of a comment. The path is dash separated like the markers, the
+// index is the key when iterating over a map.
+func (s *Syntax) findDirective(node *html.Node) *LoopMarker {
+	if node.Type != html.CommentNode {
+		return nil
+	}
+	if markers := s.FindDirectives("<!--" + node.Data + "-->"); len(markers) > 0 {
+		return markers[0]
 	}
+	return nil
 }
 
-func replaceNodeWithMap(
-	node *html.Node,
-	args *PropertyWithContext,
-) {
-	if node.Type == html.CommentNode {
-		return
+func (s *Syntax) isEndDirective(node *html.Node) bool {
+	return node.Type == html.CommentNode && s.IsEndDirective(node.Data)
+}
+
+// IsEndDirective reports whether the text of a comment is `ssr:end`.
+func (s *Syntax) IsEndDirective(comment string) bool {
+	return s.endRegex.MatchString(comment)
+}
+
+// FindDirectives returns all the `<!--ssr:each-->` directives in the text.
+func (s *Syntax) FindDirectives(text string) []*LoopMarker {
+	var markers []*LoopMarker
+	for _, result := range s.eachRegex.FindAllStringSubmatch(text, -1) {
+		path := strings.Split(result[1], "-")
+		marker := &LoopMarker{
+			Text:     strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(result[0], "<!--"), "-->")),
+			PropName: path[len(path)-1],
+			ValName:  result[2],
+		}
+		if len(path) > 1 {
+			marker.Prefix = path[:len(path)-1]
+		}
+		marker.Index = result[3]
+		markers = append(markers, marker)
 	}
-	parent := node.Parent
-	recursiveMap(node, replaceMapProp, args)
-	swapNodeChildren(parent, createNode(args.context))
+	return markers
 }
 
-func createNode(context *Context) *html.Node {
-	propName := context.PropName
-	var valueName string
+func findLoopMarker(node *html.Node, syntax *Syntax) *LoopMarker {
+	for _, attr := range node.Attr {
+		if attr.Key != "class" {
+			continue
+		}
+		if markers := syntax.FindLoopMarkers(attr.Val); len(markers) > 0 {
+			return markers[0]
+		}
+	}
+	return nil
+}
 
-	if context.LoopContext != nil {
-		valueName = context.LoopContext.IndexName
-	} else if context.MapContext != nil {
-		valueName = context.MapContext.ValName
-	} else {
-		panic("Could not find the value name")
+// Find the `if-[propPath]--`, `elif-[propPath]--` or `else--` class of the
+// node, returning the keyword and the property path.
+func (s *Syntax) findCondition(node *html.Node) (string, []string, bool) {
+	for _, attr := range node.Attr {
+		if attr.Key != "class" {
+			continue
+		}
+		for _, class := range strings.Fields(attr.Val) {
+			if result := s.ifRegex.FindStringSubmatch(class); result != nil {
+				return "if", strings.Split(result[1], "-"), true
+			}
+			if result := s.elifRegex.FindStringSubmatch(class); result != nil {
+				return "else if", strings.Split(result[1], "-"), true
+			}
+			if class == s.ElseMarker {
+				return "else", nil, true
+			}
+		}
 	}
+	return "", nil, false
+}
 
-	if context.PrevContext == nil {
-		return &html.Node{
-			Type: html.ElementNode,
-			Data: fmt.Sprintf("for _, %s := range props.%s {", valueName, propName),
+func createConditionNode(
+	node *html.Node,
+	keyword string,
+	path []string,
+	args *modifyHTMLArgs,
+) (*html.Node, error) {
+	if keyword != "if" {
+		prev := prevSibling(node)
+		if prev == nil || !(strings.HasPrefix(prev.Data, "if ") ||
+			strings.HasPrefix(prev.Data, "} else if ")) {
+			return nil, &MarkerError{args.syntax.conditionMarker(keyword, path), errors.New("could not find the if block")}
 		}
+		if keyword == "else" {
+			return &html.Node{Type: html.ElementNode, Data: "} else {"}, nil
+		}
+		keyword = "} " + keyword
+	}
+
+	expr, fieldType, err := resolveCondition(args.props, args.context, path)
+	if err != nil {
+		return nil, &MarkerError{args.syntax.conditionMarker(keyword, path), err}
 	}
-	iterName := getIterName(context)
 	return &html.Node{
 		Type: html.ElementNode,
-		Data: fmt.Sprintf(
-			"for _, %s := range %s.%s {",
-			valueName, iterName, propName,
-		),
+		Data: fmt.Sprintf("%s %s {", keyword, fieldType.Truthy(expr)),
+	}, nil
+}
+
+// The class of a condition, e.g. `elif-user-admin--`
+func (s *Syntax) conditionMarker(keyword string, path []string) string {
+	switch keyword {
+	case "if":
+		return s.IfPrefix + strings.Join(path, "-") + "--"
+	case "else":
+		return s.ElseMarker
+	default:
+		return s.ElifPrefix + strings.Join(path, "-") + "--"
 	}
 }
 
-func replaceMapProp(node *html.Node, args *PropertyWithContext) {
-	recursiveMap(node, replaceMapProp, args)
-	currentProp := args.currentProp
-	context := args.context
-	propName := context.PropName
+// Resolve the path of a condition, which may start with a loop variable, to
+// the Go expression and the type of the value.
+func resolveCondition(
+	props map[string]*Property,
+	context *Context,
+	path []string,
+) (string, *types.Type, error) {
+	if len(path) == 1 {
+		if variableType := context.loopVariable(path[0]); variableType != nil {
+			return path[0], variableType, nil
+		}
+	}
+	for c := context; c.Prop != nil; c = c.PrevContext {
+		if c.valueName() == path[0] {
+			path = append(append([]string{}, c.Path...), path[1:]...)
+			break
+		}
+	}
 
-	index := getPropIndex(node, propName)
-	currentLine := context.MapContext.ValName + node.Data[index+len(propName)+1:]
+	expr, prop, loop := resolvePath(props, context, path)
+	if loop != nil {
+		return expr, loop.Prop.Type.ElementType(), nil
+	}
 
-	// Given the current line and current prop, find the field that is being accessed
-	finalDotIndex := strings.LastIndex(currentLine, ".")
-	if finalDotIndex == -1 {
-		modifyNodeData(currentLine, currentProp, types.MapTypeToStringFunc, node)
-	} else {
-		field := currentLine[finalDotIndex+1:]
-		field = field[:findFinal(field)]
+	if prop == nil {
+		prop = lookupProp(props, path)
+	}
+	if prop == nil {
+		return "", nil, errPropNotFound
+	}
+	return expr, prop.Type, nil
+}
 
-		prop := findProps(currentProp.Children, field)
-		if prop == nil {
-			panic("Could not find the prop in the current prop")
+// Resolve a property path to the Go expression reading it in the context.
+// When the path is the value of a loop, that loop context is returned instead
+// of the property.
+func resolvePath(
+	props map[string]*Property,
+	context *Context,
+	path []string,
+) (string, *Property, *Context) {
+	for c := context; c.Prop != nil; c = c.PrevContext {
+		if !hasPrefix(path, c.Path) {
+			continue
 		}
-
-		modifyNodeDataOther(currentLine, prop, types.MapTypeToStringFunc, node)
+		rest := path[len(c.Path):]
+		c.valueUsed = true
+		if len(rest) == 0 {
+			return c.valueName(), nil, c
+		}
+		return c.valueName() + goPath(c.Prop.Children, rest), lookupProp(c.Prop.Children, rest), nil
 	}
+	return "props" + goPath(props, path), nil, nil
 }
 
-func getPropIndex(node *html.Node, propName string) int {
-	if strings.Contains(node.Data, "."+propName) {
-		node.Data = strings.ReplaceAll(node.Data, "props.", ".")
-		index := strings.Index(node.Data, "."+propName)
-		if index == -1 {
-			panic("Could not find the prop")
+// Convert a property path to the selector of the Go fields, e.g. `.User.Name`
+func goPath(props map[string]*Property, path []string) string {
+	var selector strings.Builder
+	for _, part := range path {
+		prop := props[part]
+		if prop == nil || prop.GoName == "" {
+			selector.WriteString("." + part)
+			props = nil
+			continue
 		}
-		return index
+		selector.WriteString("." + prop.GoName)
+		props = prop.Children
 	}
-	panic("Could not find the prop")
+	return selector.String()
 }
 
-func modifyNodeData(
-	currentLine string,
-	currentProp *Property,
-	typeCasts map[string]string,
-	node *html.Node,
-) {
-	typeCast, ok := typeCasts[currentProp.Type]
-	if !ok {
-		typeCast = types.DefaultType
+func hasPrefix(path []string, prefix []string) bool {
+	if len(prefix) > len(path) {
+		return false
 	}
+	for i := range prefix {
+		if path[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
 
-	trimmed := strings.TrimSuffix(currentLine, " }")
-	trimmed = strings.TrimSuffix(trimmed, ")")
+// Insert the wrapper in place of the node and move the node into it.
+func wrapNode(node *html.Node, wrapper *html.Node) {
+	node.Parent.InsertBefore(wrapper, node)
+	node.Parent.RemoveChild(node)
+	wrapper.AppendChild(node)
+}
 
-	if typeCast == "" {
-		node.Data = "{ " + trimmed + " }"
-	} else {
-		node.Data = "{ " + typeCast + "(" + strings.TrimSuffix(trimmed, " }") + ")" + " }"
+func swapNodeChildren(parent *html.Node, node *html.Node) {
+	for c := parent.FirstChild; c != nil; c = parent.FirstChild {
+		parent.RemoveChild(c)
+		node.AppendChild(c)
 	}
+	parent.AppendChild(node)
 }
 
-// TODO(czarlinski): rewrite this.
-func modifyNodeDataOther(
-	currentLine string,
-	currentProp *Property,
-	typeCasts map[string]string,
-	node *html.Node,
-) {
-	typeCast, ok := typeCasts[currentProp.Type]
-	if !ok {
-		typeCast = types.DefaultType
+// Create the `for` node of the loop. Its header is written once the children
+// are modified, since the variables are only declared if they use them.
+func createNode(args *modifyHTMLArgs, context *Context) *html.Node {
+	context.loop = &html.Node{Type: html.ElementNode}
+	writeLoopHeader(args, context)
+	return context.loop
+}
+
+func writeLoopHeader(args *modifyHTMLArgs, context *Context) {
+	iterName, _, _ := resolvePath(args.props, context.PrevContext, context.Path)
+	index := ""
+	if loop := context.LoopContext; loop != nil && loop.indexUsed {
+		index = loop.Index
+	} else if m := context.MapContext; m != nil {
+		// Go iterates over maps in random order
+		iterName = args.conversion.Entries(iterName)
+		if m.keyUsed {
+			index = m.KeyName
+		}
 	}
-	trimmed := strings.Trim(strings.TrimSuffix(currentLine, " }"), ")")
-	if typeCast == "" {
-		node.Data = "{ " + trimmed + " }"
-	} else {
-		node.Data = "{ " + typeCast + "(" + trimmed + ")" + " }"
+
+	// Unused variables do not compile, so only the used ones are declared
+	switch {
+	case context.valueUsed && index == "":
+		context.loop.Data = fmt.Sprintf("for _, %s := range %s {", context.valueName(), iterName)
+	case context.valueUsed:
+		context.loop.Data = fmt.Sprintf("for %s, %s := range %s {", index, context.valueName(), iterName)
+	case index != "":
+		context.loop.Data = fmt.Sprintf("for %s := range %s {", index, iterName)
+	default:
+		context.loop.Data = fmt.Sprintf("for range %s {", iterName)
 	}
 }
 
-// Find the next space, right bracket, or right curly brace
-func findFinal(currentLine string) int {
-	final := strings.LastIndex(currentLine, " ")
-	if final != -1 {
-		return final
+// Replace the `{ props.[propPath] }` expressions with the loop variables inside
+// of loops, converting the values to strings.
+func replaceExpressions(node *html.Node, args *modifyHTMLArgs) error {
+	var err error
+	if node.Type == html.TextNode {
+		node.Data, err = replaceExpression(node.Data, args)
+	} else if node.Type == html.ElementNode {
+		for i := range node.Attr {
+			node.Attr[i].Val, err = replaceExpression(node.Attr[i].Val, args)
+			if err != nil {
+				break
+			}
+		}
 	}
+	return err
+}
 
-	final = strings.LastIndex(currentLine, ")")
-	if final != -1 {
-		return final
-	}
+func replaceExpression(data string, args *modifyHTMLArgs) (string, error) {
+	var err error
+	data = expressionRegex.ReplaceAllStringFunc(data, func(match string) string {
+		path := strings.Split(expressionRegex.FindStringSubmatch(match)[1], ".")
+		if len(path) == 1 {
+			if variableType := args.context.loopVariable(path[0]); variableType != nil {
+				return "{ " + args.conversion.ToString(variableType, path[0]) + " }"
+			}
+		}
+		expr, prop, loop := resolvePath(args.props, args.context, path)
+		if loop != nil {
+			return "{ " + args.conversion.ToString(loop.Prop.Type.ElementType(), expr) + " }"
+		}
+		if prop == nil {
+			prop = lookupProp(args.props, path)
+		}
+		if prop == nil {
+			if err == nil {
+				err = &MarkerError{args.syntax.PropPrefix + strings.Join(path, "-"), errPropNotFound}
+			}
+			return match
+		}
+		return "{ " + args.conversion.ToString(prop.Type, expr) + " }"
+	})
+	return data, err
+}
 
-	final = strings.LastIndex(currentLine, "}")
-	if final != -1 {
-		return final
+// ReplaceExpressions converts the `{ props.[propPath] }` expressions in HTML
+// that does not need to be parsed, returning the HTML and the packages used.
+func ReplaceExpressions(
+	props map[string]*Property,
+	data string,
+	opts *Options,
+) (string, []string, error) {
+	args := &modifyHTMLArgs{
+		props:      props,
+		context:    &Context{},
+		conversion: opts.conversion(),
+		syntax:     opts.syntax(),
+	}
+	data, err := replaceExpression(data, args)
+	if err != nil {
+		return "", nil, err
 	}
-	panic("Could not find the end of the field")
+	return data, args.conversion.Imports(), nil
 }
 
 func recursiveMap[Args any](
@@ -391,32 +914,30 @@ func recursiveMap[Args any](
 	}
 }
 
-func getIterName(context *Context) string {
-	if context.PrevContext.LoopContext != nil {
-		return context.PrevContext.LoopContext.IndexName
-	} else if context.PrevContext.MapContext != nil {
-		return context.PrevContext.MapContext.ValName
-	} else {
-		return "props"
+func (context *Context) valueName() string {
+	if context.LoopContext != nil {
+		return context.LoopContext.IndexName
+	} else if context.MapContext != nil {
+		return context.MapContext.ValName
 	}
+	panic("Could not find the value name")
 }
 
-func setPrevContext(context *Context) *Context {
-	prevContext := &Context{PropName: context.PropName}
-	if context.MapContext != nil {
-		prevContext.MapContext = &MapContext{
-			KeyName: context.MapContext.KeyName,
-			ValName: context.MapContext.ValName,
+// The type of the name if it is the index or key variable of one of the
+// enclosing loops, which is then declared by the loop.
+func (context *Context) loopVariable(name string) *types.Type {
+	for c := context; c != nil && c.Prop != nil; c = c.PrevContext {
+		if c.LoopContext != nil && c.LoopContext.Index == name {
+			c.LoopContext.indexUsed = true
+			return types.NewScalar("int")
 		}
-	} else if context.LoopContext != nil {
-		prevContext.LoopContext = &LoopContext{
-			IndexName: context.LoopContext.IndexName,
+		if c.MapContext != nil && c.MapContext.KeyName == name {
+			c.MapContext.keyUsed = true
+			if c.Prop.Type.Key == nil {
+				return types.NewScalar(types.DefaultType)
+			}
+			return c.Prop.Type.Key
 		}
-	} else {
-		prevContext = nil
-	}
-	if prevContext != nil && context.PrevContext != nil {
-		prevContext.PrevContext = context.PrevContext
 	}
-	return prevContext
+	return nil
 }
@@ -0,0 +1,211 @@
+package parser
+
+import (
+	"bufio"
+	"errors"
+	"strings"
+	"svelte-ssr-to-templ/builder/types"
+	"testing"
+)
+
+// A property with the Go name derived from the name, as the builder assigns
+// it for simple names.
+func prop(name string, typeString string, children ...*Property) *Property {
+	if typeString == "" {
+		typeString = types.DefaultType
+	}
+	t, err := types.Parse(typeString)
+	if err != nil {
+		panic(err)
+	}
+	p := &Property{
+		Name:     name,
+		GoName:   strings.ToUpper(name[:1]) + name[1:],
+		JSONName: name,
+		Type:     t,
+	}
+	if len(children) > 0 {
+		p.Children = make(map[string]*Property)
+		for _, child := range children {
+			p.Children[child.Name] = child
+		}
+		p.Type = p.Type.WithChildren()
+	}
+	return p
+}
+
+func propMap(props ...*Property) map[string]*Property {
+	m := make(map[string]*Property)
+	for _, p := range props {
+		m[p.Name] = p
+	}
+	return m
+}
+
+// Parse the HTML like the builder does, which passes the whitespace of the
+// trailing newline after the markup.
+func parse(props map[string]*Property, html string) (string, error) {
+	var output strings.Builder
+	writer := bufio.NewWriter(&output)
+	_, err := Parse(props, strings.NewReader(html+" "), writer, &Options{TimeFormat: types.DefaultTimeFormat})
+	writer.Flush()
+	return output.String(), err
+}
+
+// Check that the lines appear in the output in order, ignoring indentation.
+func assertLines(t *testing.T, output string, lines ...string) {
+	t.Helper()
+	rest := output
+	for _, line := range lines {
+		index := strings.Index(rest, line)
+		if index == -1 {
+			t.Fatalf("missing %q after the previous lines in:\n%s", line, output)
+		}
+		rest = rest[index+len(line):]
+	}
+}
+
+func TestConditionChain(t *testing.T) {
+	props := propMap(prop("a", "bool"), prop("b", "string"))
+	output, err := parse(props, `<p class="if-a--">A</p><p class="elif-b--">B</p><p class="else--">C</p>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertLines(t, output,
+		"if props.A {", "<p>", "A", "</p>",
+		`} else if props.B != "" {`, "<p>", "B", "</p>",
+		"} else {", "<p>", "C", "</p>",
+		"}",
+	)
+	if strings.Count(output, "}\n") != 1 {
+		t.Errorf("the chain should be closed once:\n%s", output)
+	}
+}
+
+func TestConditionChainWithoutIf(t *testing.T) {
+	props := propMap(prop("a", "bool"))
+	_, err := parse(props, `<p>A</p><p class="else--">B</p>`)
+	var markerErr *MarkerError
+	if !errors.As(err, &markerErr) || markerErr.Marker != "else--" {
+		t.Fatalf("expected a MarkerError for else--, got %v", err)
+	}
+}
+
+func TestConditionInLoop(t *testing.T) {
+	props := propMap(prop("items", "[]", prop("label", "string"), prop("done", "bool")))
+	output, err := parse(props,
+		`<ul class="iter-items[item]--"><li class="if-item-done--">{ props.items.label }</li><li class="else--">-</li></ul>`,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertLines(t, output,
+		"<ul>",
+		"for _, item := range props.Items {",
+		"if item.Done {", "<li>", "{ item.Label }", "</li>",
+		"} else {", "<li>", "-", "</li>",
+		"</ul>",
+	)
+}
+
+func TestNestedLoops(t *testing.T) {
+	props := propMap(prop("groups", "[]", prop("name", "string"), prop("members", "[]string")))
+	output, err := parse(props,
+		`<div class="iter-groups[group]--"><h2>{ props.groups.name }</h2><ul class="iter-members[member]--"><li>{ props.groups.members }</li></ul></div>`,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertLines(t, output,
+		"for _, group := range props.Groups {",
+		"{ group.Name }",
+		"for _, member := range group.Members {",
+		"{ member }",
+	)
+}
+
+func TestPrefixedLoopPath(t *testing.T) {
+	props := propMap(prop("page", "", prop("items", "[]string"), prop("title", "string")))
+	output, err := parse(props,
+		`<h1>{ props.page.title }</h1><ul class="iter-page-items[item]--"><li>{ props.page.items }</li></ul>`,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertLines(t, output,
+		"{ props.Page.Title }",
+		"for _, item := range props.Page.Items {",
+		"{ item }",
+	)
+}
+
+func TestLoopPropNotFound(t *testing.T) {
+	_, err := parse(propMap(), `<ul class="iter-items[item]--"><li></li></ul>`)
+	var markerErr *MarkerError
+	if !errors.As(err, &markerErr) || markerErr.Marker != "iter-items[item]--" {
+		t.Fatalf("expected a MarkerError for the loop, got %v", err)
+	}
+}
+
+func TestDirectiveIndex(t *testing.T) {
+	props := propMap(prop("items", "[]string"))
+	output, err := parse(props, `<h1>-</h1><!--ssr:each items as item, i--><p>{ props.i }</p><p>{ props.items }</p><!--ssr:end-->`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertLines(t, output, "for i, item := range props.Items {", "{ js.Stringify(i) }", "{ item }", "}")
+}
+
+func TestDirectiveMapKey(t *testing.T) {
+	props := propMap(prop("scores", "{string, int}"))
+	output, err := parse(props, `<h1>-</h1><!--ssr:each scores as score, name--><p>{ props.name }: { props.scores }</p><!--ssr:end-->`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertLines(t, output, "for name, score := range js.Entries(props.Scores) {", "{ name }: { js.Stringify(score) }")
+}
+
+func TestMapMarkerOnList(t *testing.T) {
+	_, err := parse(propMap(prop("items", "[]string")), `<ul class="iter-items[key-item]--"><li></li></ul>`)
+	var markerErr *MarkerError
+	if !errors.As(err, &markerErr) || markerErr.Marker != "iter-items[key-item]--" {
+		t.Fatalf("expected a MarkerError for the map marker, got %v", err)
+	}
+}
+
+func TestDirectiveSyntax(t *testing.T) {
+	syntax := NewSyntax(Syntax{EachDirective: "loop", EndDirective: "endloop"})
+	html := `<h1>-</h1><!-- loop items as item --><p>{ props.items }</p><!-- endloop --> `
+	var output strings.Builder
+	writer := bufio.NewWriter(&output)
+	_, err := Parse(propMap(prop("items", "[]string")), strings.NewReader(html), writer, &Options{Syntax: syntax})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer.Flush()
+	assertLines(t, output.String(), "for _, item := range props.Items {", "{ item }", "}")
+	if strings.Contains(output.String(), "endloop") {
+		t.Errorf("the end directive was kept:\n%s", output.String())
+	}
+}
+
+func TestFindPropPath(t *testing.T) {
+	props := propMap(
+		prop("a", "", prop("b", "", prop("items", "[]string"))),
+		prop("z", "", prop("items", "[]string")),
+	)
+	// The shallower match wins over the deeper one in the first branch
+	if path := findPropPath(props, "items"); strings.Join(path, "-") != "z-items" {
+		t.Errorf("path = %q, want z-items", path)
+	}
+
+	props["c"] = prop("c", "", prop("items", "[]string"))
+	// Then the first by name
+	if path := findPropPath(props, "items"); strings.Join(path, "-") != "c-items" {
+		t.Errorf("path = %q, want c-items", path)
+	}
+
+	if path := findPropPath(props, "missing"); path != nil {
+		t.Errorf("path = %q, want nil", path)
+	}
+}
@@ -0,0 +1,138 @@
+package builder
+
+import (
+	"bytes"
+	"sort"
+	"svelte-ssr-to-templ/builder/parser"
+
+	"golang.org/x/net/html"
+)
+
+// The part of the HTML repeated by a loop marker or directive, in which the
+// names it declares refer to its variables.
+type loopScope struct {
+	marker     *parser.LoopMarker
+	start, end position // The children of the element, or the siblings between the directives
+	parent     *loopScope
+}
+
+// Elements without an end tag, which never contain a loop
+var voidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true, "hr": true, "img": true,
+	"input": true, "link": true, "meta": true, "param": true, "source": true, "track": true, "wbr": true,
+}
+
+// Find the scopes of the loops in the HTML, in the order they start. An outer
+// loop starts before the loops nested in it.
+func findLoopScopes(data []byte, syntax *markerSyntax) []*loopScope {
+	// The offsets where the lines start, to convert offsets to positions
+	lineStarts := []int{0}
+	for i, b := range data {
+		if b == '\n' {
+			lineStarts = append(lineStarts, i+1)
+		}
+	}
+	positionOf := func(offset int) position {
+		line := sort.SearchInts(lineStarts, offset+1)
+		return position{line, offset - lineStarts[line-1] + 1}
+	}
+
+	// The open elements and directives, the tag of a directive is empty
+	type frame struct {
+		tag   string
+		scope *loopScope
+	}
+	var stack []frame
+	var scopes []*loopScope
+	// A new scope is nested in the innermost open one
+	openScope := func(marker *parser.LoopMarker, offset int) *loopScope {
+		scope := &loopScope{marker: marker, start: positionOf(offset)}
+		for i := len(stack) - 1; i >= 0 && scope.parent == nil; i-- {
+			scope.parent = stack[i].scope
+		}
+		scopes = append(scopes, scope)
+		return scope
+	}
+	closeFrame := func(f frame, offset int) {
+		if f.scope != nil {
+			f.scope.end = positionOf(offset)
+		}
+	}
+
+	tokenizer := html.NewTokenizer(bytes.NewReader(data))
+	offset := 0
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == html.ErrorToken {
+			break
+		}
+		start := offset
+		offset += len(tokenizer.Raw())
+		token := tokenizer.Token()
+
+		switch tokenType {
+		case html.StartTagToken:
+			if voidElements[token.Data] {
+				continue
+			}
+			f := frame{tag: token.Data}
+			for _, attr := range token.Attr {
+				if attr.Key != "class" {
+					continue
+				}
+				// Only the first marker of the class makes a loop
+				if markers := syntax.FindLoopMarkers(attr.Val); len(markers) > 0 {
+					f.scope = openScope(markers[0], offset)
+				}
+			}
+			stack = append(stack, f)
+		case html.EndTagToken:
+			for i := len(stack) - 1; i >= 0; i-- {
+				if stack[i].tag == token.Data {
+					// Directives left open end with their parent
+					for _, f := range stack[i:] {
+						closeFrame(f, start)
+					}
+					stack = stack[:i]
+					break
+				}
+			}
+		case html.CommentToken:
+			if markers := syntax.FindDirectives("<!--" + token.Data + "-->"); len(markers) > 0 {
+				stack = append(stack, frame{scope: openScope(markers[0], offset)})
+			} else if syntax.IsEndDirective(token.Data) && len(stack) > 0 && stack[len(stack)-1].tag == "" {
+				closeFrame(stack[len(stack)-1], start)
+				stack = stack[:len(stack)-1]
+			}
+		}
+	}
+	for _, f := range stack {
+		closeFrame(f, len(data))
+	}
+	return scopes
+}
+
+// The innermost loop around the position declaring the name as its value,
+// index or key, if any.
+func loopDeclaring(scopes []*loopScope, pos position, name string) *parser.LoopMarker {
+	// The loops around the position are the last loop starting before it, if
+	// it did not end yet, and the loops it is nested in.
+	i := sort.Search(len(scopes), func(i int) bool { return pos.before(scopes[i].start) })
+	if i == 0 {
+		return nil
+	}
+	for scope := scopes[i-1]; scope != nil; scope = scope.parent {
+		if !pos.before(scope.end) {
+			continue
+		}
+		marker := scope.marker
+		if marker.ValName == name || marker.Index == name || marker.KeyName == name {
+			return marker
+		}
+	}
+	return nil
+}
+
+func (p position) before(q position) bool {
+	return p.line < q.line || p.line == q.line && p.col < q.col
+}
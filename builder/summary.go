@@ -0,0 +1,40 @@
+package builder
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+)
+
+type fileResult struct {
+	Component string // Path of the component relative to the queue directory
+	Cached    bool   // Skipped, since the outputs are up to date
+	Err       *FileError
+}
+
+// Write a table of the built and failed components, followed by the totals.
+func writeSummary(w io.Writer, results []*fileResult) {
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Component < results[j].Component
+	})
+
+	table := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "COMPONENT\tSTATUS\tERROR")
+	cached, failed := 0, 0
+	for _, result := range results {
+		if result.Cached {
+			cached++
+			fmt.Fprintf(table, "%s\tcached\t\n", result.Component)
+			continue
+		}
+		if result.Err == nil {
+			fmt.Fprintf(table, "%s\tok\t\n", result.Component)
+			continue
+		}
+		failed++
+		fmt.Fprintf(table, "%s\tfailed\t%s\n", result.Component, result.Err.Err)
+	}
+	table.Flush()
+	fmt.Fprintf(w, "%d built, %d cached, %d failed\n", len(results)-cached-failed, cached, failed)
+}
@@ -0,0 +1,31 @@
+package builder
+
+import (
+	"context"
+	"strings"
+)
+
+// Transform converts a single SSR snippet into the component called name,
+// returning the Go source of the props struct and the templ source. The head
+// lines are the contents of the `.head` file and may be empty. Only the
+// options affecting the generated code are used, nothing is read from or
+// written to the filesystem.
+func Transform(html string, head []string, name string, opts *BuildOptions) (string, string, error) {
+	if opts == nil {
+		opts = &BuildOptions{}
+	}
+	if err := validateJSONNaming(opts.JSONNaming); err != nil {
+		return "", "", err
+	}
+
+	var headContent string
+	if len(head) > 0 {
+		headContent = strings.Join(head, "\n") + "\n"
+	}
+	src := &source{Name: name, Path: name + ".html", HTML: []byte(html), Head: []byte(headContent)}
+	goSource, templSource, err := generateComponent(context.Background(), src, opts)
+	if err != nil {
+		return "", "", err
+	}
+	return string(goSource), string(templSource), nil
+}
@@ -1,88 +1,322 @@
 package types
 
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
 const DefaultType string = "string"
 
-var FieldTypeMap = map[string]string{
-	"string":             "string",
-	"int":                "int",
-	"bool":               "bool",
-	"[]":                 "[]string",
-	"[]string":           "[]string",
-	"[]int":              "[]int",
-	"[]bool":             "[]bool",
-	"{string, string}":   "map[string]string",
-	"{string, int}":      "map[string]int",
-	"{string, bool}":     "map[string]bool",
-	"{string, []string}": "map[string][]string",
-	"{string, []int}":    "map[string][]int",
-	"{string, []bool}":   "map[string][]bool",
-	"{int, string}":      "map[int]string",
-	"{int, int}":         "map[int]int",
-	"{int, bool}":        "map[int]bool",
-	"{int, []string}":    "map[int][]string",
-	"{int, []int}":       "map[int][]int",
-	"{int, []bool}":      "map[int][]bool",
-	"{bool, string}":     "map[bool]string",
-	"{bool, int}":        "map[bool]int",
-	"{bool, bool}":       "map[bool]bool",
-	"{bool, []string}":   "map[bool][]string",
-	"{bool, []int}":      "map[bool][]int",
-	"{bool, []bool}":     "map[bool][]bool",
-}
-
-// TODO(czarlinski): some of the type casts are incorrect.
-var ListTypeToStringFunc = map[string]string{
-	"string":             "",
-	"int":                "strconv.Itoa",
-	"bool":               "strconv.FormatBool",
-	"[]":                 "string",
-	"[]string":           "string",
-	"[]int":              "string",
-	"[]bool":             "string",
-	"{string, string}":   "string",
-	"{string, int}":      "string",
-	"{string, bool}":     "string",
-	"{string, []string}": "string",
-	"{string, []int}":    "string",
-	"{string, []bool}":   "string",
-	"{int, string}":      "string",
-	"{int, int}":         "string",
-	"{int, bool}":        "string",
-	"{int, []string}":    "string",
-	"{int, []int}":       "string",
-	"{int, []bool}":      "string",
-	"{bool, string}":     "string",
-	"{bool, int}":        "string",
-	"{bool, bool}":       "string",
-	"{bool, []string}":   "string",
-	"{bool, []int}":      "string",
-	"{bool, []bool}":     "string",
-}
-
-var MapTypeToStringFunc = map[string]string{
-	"string":             "",
-	"int":                "strconv.Itoa",
-	"bool":               "strconv.FormatBool",
-	"[]":                 "string",
-	"[]string":           "string",
-	"[]int":              "string",
-	"[]bool":             "string",
-	"{string, string}":   "",
-	"{string, int}":      "strconv.Itoa",
-	"{string, bool}":     "strconv.FormatBool",
-	"{string, []string}": "string",
-	"{string, []int}":    "string",
-	"{string, []bool}":   "string",
-	"{int, string}":      "",
-	"{int, int}":         "strconv.Itoa",
-	"{int, bool}":        "strconv.FormatBool",
-	"{int, []string}":    "string",
-	"{int, []int}":       "string",
-	"{int, []bool}":      "string",
-	"{bool, string}":     "",
-	"{bool, int}":        "strconv.Itoa",
-	"{bool, bool}":       "strconv.FormatBool",
-	"{bool, []string}":   "string",
-	"{bool, []int}":      "string",
-	"{bool, []bool}":     "string",
+// DefaultTimeFormat matches how time.Time is encoded in the JSON props, which
+// is what the Svelte component renders after hydration.
+const DefaultTimeFormat string = time.RFC3339Nano
+
+type Kind int
+
+const (
+	Scalar Kind = iota
+	List
+	Map
+	Struct // Generated from the children of a property
+)
+
+var scalarTypes = map[string]string{
+	"string": "string",
+	"int":    "int",
+	"int64":  "int64",
+	"uint":   "uint",
+	"float":  "float64",
+	"bool":   "bool",
+	"time":   "time.Time",
+}
+
+// The scalars the keys of JSON objects can be encoded from, time.Time is an
+// encoding.TextMarshaler
+var mapKeyTypes = map[string]bool{"string": true, "int": true, "int64": true, "uint": true, "time": true}
+
+// Type is a parsed type annotation of a `svelte-name{type}-` marker.
+//
+//	type   = scalar | list | map
+//	scalar = "string" | "int" | "int64" | "uint" | "float" | "bool" | "time"
+//	list   = "[]" [ type ]
+//	map    = "{" key "," type "}"
+//	key    = "string" | "int" | "int64" | "uint" | "time"
+//
+// A list without an element type holds strings, or the struct generated for
+// the children of the property.
+type Type struct {
+	Kind Kind
+	Name string // Only set for scalars
+	Key  *Type  // Only set for maps
+	Elem *Type  // Element of a list or value of a map, nil for `[]`
+}
+
+func NewScalar(name string) *Type {
+	return &Type{Kind: Scalar, Name: name}
+}
+
+// WithChildren returns the type of a property with children, replacing the
+// element of a list without an element type, or the type itself, with the
+// generated struct.
+func (t *Type) WithChildren() *Type {
+	if t.Kind != List {
+		return &Type{Kind: Struct}
+	}
+	if t.Elem == nil {
+		return &Type{Kind: List, Elem: &Type{Kind: Struct}}
+	}
+	return &Type{Kind: List, Elem: t.Elem.WithChildren()}
+}
+
+// Parse parses a type annotation, e.g. `{string, [][]int}`.
+func Parse(annotation string) (*Type, error) {
+	p := &typeParser{input: annotation}
+	t, err := p.parseType()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpaces()
+	if p.pos != len(p.input) {
+		return nil, p.errorf("unexpected %q", p.input[p.pos:])
+	}
+	return t, nil
+}
+
+type typeParser struct {
+	input string
+	pos   int
+}
+
+func (p *typeParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("invalid type %q at offset %d: %s", p.input, p.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *typeParser) skipSpaces() {
+	for p.pos < len(p.input) && p.input[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+func (p *typeParser) consume(token string) bool {
+	p.skipSpaces()
+	if strings.HasPrefix(p.input[p.pos:], token) {
+		p.pos += len(token)
+		return true
+	}
+	return false
+}
+
+func (p *typeParser) parseType() (*Type, error) {
+	if p.consume("[]") {
+		p.skipSpaces()
+		if p.pos == len(p.input) || p.input[p.pos] == ',' || p.input[p.pos] == '}' {
+			return &Type{Kind: List}, nil
+		}
+		elem, err := p.parseType()
+		if err != nil {
+			return nil, err
+		}
+		return &Type{Kind: List, Elem: elem}, nil
+	}
+
+	if p.consume("{") {
+		p.skipSpaces()
+		keyStart := p.pos
+		key, err := p.parseType()
+		if err != nil {
+			return nil, err
+		}
+		if key.Kind != Scalar || !mapKeyTypes[key.Name] {
+			p.pos = keyStart
+			return nil, p.errorf("map keys must be string, int, int64, uint or time")
+		}
+		if !p.consume(",") {
+			return nil, p.errorf("expected ','")
+		}
+		value, err := p.parseType()
+		if err != nil {
+			return nil, err
+		}
+		if !p.consume("}") {
+			return nil, p.errorf("expected '}'")
+		}
+		return &Type{Kind: Map, Key: key, Elem: value}, nil
+	}
+
+	p.skipSpaces()
+	start := p.pos
+	for p.pos < len(p.input) && isLetter(p.input[p.pos]) {
+		p.pos++
+	}
+	name := p.input[start:p.pos]
+	if _, ok := scalarTypes[name]; !ok {
+		p.pos = start
+		return nil, p.errorf("unknown type %q", name)
+	}
+	return NewScalar(name), nil
+}
+
+func isLetter(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9'
+}
+
+// String returns the normalised annotation of the type.
+func (t *Type) String() string {
+	switch t.Kind {
+	case List:
+		if t.Elem == nil {
+			return "[]"
+		}
+		return "[]" + t.Elem.String()
+	case Map:
+		return "{" + t.Key.String() + ", " + t.Elem.String() + "}"
+	case Struct:
+		return "struct"
+	default:
+		return t.Name
+	}
+}
+
+// GoType returns the Go type of the field.
+func (t *Type) GoType() string {
+	return t.GoTypeOf(scalarTypes[DefaultType])
+}
+
+// GoTypeOf returns the Go type of the field, using structName for the
+// generated struct.
+func (t *Type) GoTypeOf(structName string) string {
+	switch t.Kind {
+	case List:
+		if t.Elem == nil {
+			return "[]" + scalarTypes[DefaultType]
+		}
+		return "[]" + t.Elem.GoTypeOf(structName)
+	case Map:
+		return "map[" + t.Key.GoType() + "]" + t.Elem.GoType()
+	case Struct:
+		return structName
+	default:
+		return scalarTypes[t.Name]
+	}
+}
+
+// ElementType returns the type of the values iterated over in a list or map.
+func (t *Type) ElementType() *Type {
+	switch t.Kind {
+	case List, Map:
+		if t.Elem == nil {
+			return NewScalar(DefaultType)
+		}
+		return t.Elem
+	default:
+		return t
+	}
+}
+
+// Uses reports whether the scalar type is part of the type.
+func (t *Type) Uses(name string) bool {
+	switch t.Kind {
+	case List:
+		return t.Elem != nil && t.Elem.Uses(name)
+	case Map:
+		return t.Key.Uses(name) || t.Elem.Uses(name)
+	case Struct:
+		return false
+	default:
+		return t.Name == name
+	}
+}
+
+// RuntimePackage is imported by the generated templates to render values,
+// unless another import path is configured.
+const RuntimePackage string = "svelte-ssr-to-templ/js"
+
+// Conversion generates the code converting values to strings the way Svelte
+// renders them, recording the packages the code needs.
+type Conversion struct {
+	TimeFormat     string // Go layout used to render time.Time values
+	RuntimePackage string // Import path of the js package, defaults to RuntimePackage
+
+	imports map[string]struct{}
+}
+
+// Imports returns the sorted packages used by the generated conversions.
+func (c *Conversion) Imports() []string {
+	imports := make([]string, 0, len(c.imports))
+	for name := range c.imports {
+		imports = append(imports, name)
+	}
+	sort.Strings(imports)
+	return imports
+}
+
+func (c *Conversion) use(name string) {
+	if c.imports == nil {
+		c.imports = make(map[string]struct{})
+	}
+	c.imports[name] = struct{}{}
+}
+
+// The generated code refers to the runtime package as `js`, which is aliased
+// when the import path ends in another name.
+func (c *Conversion) useRuntime() {
+	name := c.RuntimePackage
+	if name == "" {
+		name = RuntimePackage
+	}
+	if path.Base(name) != "js" {
+		name = "js " + strconv.Quote(name)
+	}
+	c.use(name)
+}
+
+// ToString returns the expression converting expr of the type to a string.
+// Lists are joined with commas and objects become `[object Object]`, like
+// JavaScript's String().
+func (c *Conversion) ToString(t *Type, expr string) string {
+	switch {
+	case t.Kind == Scalar && t.Name == "string":
+		return expr
+	case t.Kind == Scalar && t.Name == "time":
+		return expr + ".Format(" + strconv.Quote(c.TimeFormat) + ")"
+	case t.Uses("time") && c.TimeFormat != DefaultTimeFormat:
+		c.useRuntime()
+		return "js.StringifyTime(" + expr + ", " + strconv.Quote(c.TimeFormat) + ")"
+	default:
+		c.useRuntime()
+		return "js.Stringify(" + expr + ")"
+	}
+}
+
+// Entries returns the expression iterating over the map expr in the order
+// JavaScript iterates over the object.
+func (c *Conversion) Entries(expr string) string {
+	c.useRuntime()
+	return "js.Entries(" + expr + ")"
+}
+
+// Truthy returns a Go boolean expression that mirrors the JavaScript
+// truthiness of expr, as used by Svelte's `{#if}` blocks.
+func (t *Type) Truthy(expr string) string {
+	switch {
+	case t.Kind == Struct:
+		// Objects are always truthy
+		return "true"
+	case t.Kind != Scalar:
+		// Slices and maps. JavaScript treats empty arrays and objects as truthy,
+		// only a missing value (null) is falsy.
+		return expr + " != nil"
+	case t.Name == "bool":
+		return expr
+	case t.Name == "string":
+		return expr + ` != ""`
+	case t.Name == "time":
+		return "!" + expr + ".IsZero()"
+	default:
+		return expr + " != 0"
+	}
 }
@@ -0,0 +1,60 @@
+package types
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		annotation string
+		normalised string
+		goType     string
+	}{
+		{"string", "string", "string"},
+		{"float", "float", "float64"},
+		{"time", "time", "time.Time"},
+		{"[]", "[]", "[]string"},
+		{"[]int", "[]int", "[]int"},
+		{"[][]int", "[][]int", "[][]int"},
+		{"{string,int}", "{string, int}", "map[string]int"},
+		{"{string, {string, int}}", "{string, {string, int}}", "map[string]map[string]int"},
+		{" { int64 , [] bool } ", "{int64, []bool}", "map[int64][]bool"},
+		{"{time, []}", "{time, []}", "map[time.Time][]string"},
+		{"{uint, string}", "{uint, string}", "map[uint]string"},
+	}
+	for _, test := range tests {
+		typ, err := Parse(test.annotation)
+		if err != nil {
+			t.Errorf("%q: %v", test.annotation, err)
+			continue
+		}
+		if typ.String() != test.normalised || typ.GoType() != test.goType {
+			t.Errorf("%q: got %s and %s, want %s and %s", test.annotation, typ, typ.GoType(), test.normalised, test.goType)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		annotation string
+		want       string
+	}{
+		{"", `unknown type ""`},
+		{"integer", `at offset 0: unknown type "integer"`},
+		{"[]map", `at offset 2: unknown type "map"`},
+		{"{string int}", "expected ','"},
+		{"{string, int", "expected '}'"},
+		{"int]", `unexpected "]"`},
+		{"{[]string, int}", "at offset 1: map keys must be string, int, int64, uint or time"},
+		{"{bool, string}", "at offset 1: map keys must be string, int, int64, uint or time"},
+		{"{ float, string}", "at offset 2: map keys must be string, int, int64, uint or time"},
+		{"{{string, int}, int}", "map keys must be"},
+	}
+	for _, test := range tests {
+		_, err := Parse(test.annotation)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%q: got %v, want an error containing %q", test.annotation, err, test.want)
+		}
+	}
+}
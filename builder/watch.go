@@ -0,0 +1,138 @@
+package builder
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"sort"
+	"time"
+)
+
+// The modification time and size of the `.html` and `.head` file of a
+// component, used to detect changes without reading the files.
+type componentState struct {
+	html, head fileState
+}
+
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// WatchEvent describes one build of Watch.
+type WatchEvent struct {
+	Built   []string // Components that were rebuilt, without the cached and failed ones
+	Removed []string // Components whose outputs were removed
+	Err     error    // The errors of the failed components, as returned by Build
+}
+
+// Watch builds all components, then polls the input every interval and
+// rebuilds the components whose `.html` or `.head` file changed, removing the
+// outputs of deleted components. A failing component does not stop the others
+// from being built, as with ContinueOnError, and is only built again once its
+// files change. The result of every build is passed to onBuild. Watch returns
+// when ctx is done, or when the input can no longer be read.
+func Watch(ctx context.Context, opts *BuildOptions, interval time.Duration, onBuild func(*WatchEvent)) error {
+	opts, err := resolveOptions(opts)
+	if err != nil {
+		return err
+	}
+	opts.ContinueOnError = true
+
+	// The state of the components when they were last built, cached or failed
+	previous := map[string]componentState{}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		current, err := snapshot(opts.Input)
+		if err != nil {
+			return err
+		}
+
+		event := &WatchEvent{}
+		var changed []string
+		for _, component := range sortedKeys(current) {
+			if state, exists := previous[component]; !exists || state != current[component] {
+				changed = append(changed, component)
+			}
+		}
+		for _, component := range sortedKeys(previous) {
+			if _, exists := current[component]; !exists {
+				event.Removed = append(event.Removed, component)
+			}
+		}
+
+		if len(changed) > 0 || len(event.Removed) > 0 {
+			var errs []error
+			if len(changed) > 0 {
+				results, err := buildComponents(ctx, opts, changed)
+				// Components without a result were never started
+				for _, result := range results {
+					previous[result.Component] = current[result.Component]
+					if !result.Cached && result.Err == nil {
+						event.Built = append(event.Built, result.Component)
+					}
+				}
+				sort.Strings(event.Built)
+				errs = append(errs, err)
+			}
+			if len(event.Removed) > 0 {
+				err := removeComponents(opts.Output, event.Removed)
+				if err == nil {
+					for _, component := range event.Removed {
+						delete(previous, component)
+					}
+				}
+				errs = append(errs, err)
+			}
+			event.Err = errors.Join(errs...)
+			if ctx.Err() != nil {
+				return nil
+			}
+			onBuild(event)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// The state of every component of the input.
+func snapshot(input fs.FS) (map[string]componentState, error) {
+	components, err := findComponents(input)
+	if err != nil {
+		return nil, err
+	}
+	states := make(map[string]componentState, len(components))
+	for _, component := range components {
+		html, err := statFile(input, component+".html")
+		if err != nil {
+			// Removed since walking the input, it is picked up next time
+			continue
+		}
+		// The head file is optional while the component is being written
+		head, _ := statFile(input, component+".head")
+		states[component] = componentState{html, head}
+	}
+	return states, nil
+}
+
+func statFile(input fs.FS, name string) (fileState, error) {
+	info, err := fs.Stat(input, name)
+	if err != nil {
+		return fileState{}, err
+	}
+	return fileState{info.ModTime(), info.Size()}, nil
+}
+
+func sortedKeys(states map[string]componentState) []string {
+	keys := make([]string, 0, len(states))
+	for key := range states {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
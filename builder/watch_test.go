@@ -0,0 +1,98 @@
+package builder
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// An output failing the first writes, like a full disk that was cleaned up.
+// Only the writes of files with the suffix fail, if one is given.
+type flakyOutput struct {
+	MemoryOutput
+	mutex    sync.Mutex
+	failures int
+	suffix   string
+}
+
+func (o *flakyOutput) WriteFile(name string, data []byte) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if o.failures > 0 && strings.HasSuffix(name, o.suffix) {
+		o.failures--
+		return errors.New("disk full")
+	}
+	return o.MemoryOutput.WriteFile(name, data)
+}
+
+// An input counting the polls of Watch, which walk it from its root.
+// onPoll runs before the poll reads the input.
+type polledInput struct {
+	fstest.MapFS
+	polls  int
+	onPoll func(polls int)
+}
+
+func (input *polledInput) ReadDir(name string) ([]fs.DirEntry, error) {
+	if name == "." {
+		input.polls++
+		input.onPoll(input.polls)
+	}
+	return input.MapFS.ReadDir(name)
+}
+
+func TestWatchRetriesFailedComponentsOnChange(t *testing.T) {
+	input := &polledInput{MapFS: fstest.MapFS{
+		"bad.html":  component(`<p>svelte-x{bogus}--</p>`),
+		"bad.head":  component(""),
+		"good.html": component(`<p>svelte-title--</p>`),
+		"good.head": component(""),
+	}}
+	// The broken component is fixed after some polls
+	input.onPoll = func(polls int) {
+		if polls == 20 {
+			input.MapFS["bad.html"] = component(`<p>svelte-x{int}--</p>`)
+		}
+	}
+	output := &MemoryOutput{}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var events []*WatchEvent
+	var polls []int
+	err := Watch(ctx, &BuildOptions{Input: input, Output: output}, time.Millisecond, func(event *WatchEvent) {
+		events = append(events, event)
+		polls = append(polls, input.polls)
+		if len(events) == 2 {
+			cancel()
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 2 {
+		t.Fatalf("got %d builds, want the first build and the one of the fix", len(events))
+	}
+	if events[0].Err == nil || !strings.Contains(events[0].Err.Error(), "bad.html") {
+		t.Errorf("the first build did not fail on bad.html: %v", events[0].Err)
+	}
+	// The failure does not stop the other components
+	if want := []string{"good"}; !slices.Equal(events[0].Built, want) {
+		t.Errorf("built %q, want %q", events[0].Built, want)
+	}
+	// The failed component is not built again until it changes
+	if polls[1] != 20 {
+		t.Errorf("the second build was at poll %d, want 20", polls[1])
+	}
+	if want := []string{"bad"}; events[1].Err != nil || !slices.Equal(events[1].Built, want) {
+		t.Errorf("built %q with %v, want %q", events[1].Built, events[1].Err, want)
+	}
+	assertFiles(t, output, []string{"bad/bad.go", "good/good.templ"}, nil)
+}
@@ -1,19 +1,42 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"os"
+	"os/signal"
 	"path"
 	"strings"
 	"svelte-ssr-to-templ/builder"
-
-	"golang.org/x/sync/errgroup"
+	"svelte-ssr-to-templ/builder/parser"
+	"time"
 )
 
 var (
 	queueDir       = flag.String("in", "", "Directory containing the files to be processed")
 	outputBuildDir = flag.String("out", "", "Directory to output the built files")
+	jobs           = flag.Int("j", 0, "Number of components built at once, defaults to the number of CPUs")
 	hash           = flag.String("hash", "", "The hash to suffix the output files with")
+	timeFormat     = flag.String("time-format", "", "Go layout used to render time props, defaults to RFC 3339")
+	jsonNaming     = flag.String("json-naming", builder.JSONNamingPreserve, "JSON keys of the props: preserve, camel, snake or explicit")
+	runtimePackage = flag.String("runtime-package", "", "Import path of the js package in the generated templates, e.g. a vendored copy")
+	propPrefix     = flag.String("prop-prefix", parser.DefaultPropPrefix, "Prefix of the prop markers")
+	loopPrefix     = flag.String("loop-prefix", parser.DefaultLoopPrefix, "Prefix of the loop markers")
+	mapPrefix      = flag.String("map-prefix", parser.DefaultMapPrefix, "Prefix of the map markers")
+	ifPrefix       = flag.String("if-prefix", parser.DefaultIfPrefix, "Prefix of the if markers")
+	elifPrefix     = flag.String("elif-prefix", parser.DefaultElifPrefix, "Prefix of the else if markers")
+	elseMarker     = flag.String("else-marker", parser.DefaultElseMarker, "Class of the else markers")
+	eachDirective  = flag.String("each-directive", parser.DefaultEachDirective, "Keyword of the loop directives in HTML comments")
+	endDirective   = flag.String("end-directive", parser.DefaultEndDirective, "Keyword of the comments ending a loop directive")
+	maxInputSize   = flag.Int64("max-input-size", builder.DefaultMaxInputSize, "Largest input file in bytes")
+	keepGoing      = flag.Bool("continue-on-error", false, "Keep building the other components when one fails and print a summary")
+	noCache        = flag.Bool("no-cache", false, "Rebuild every component, even if its inputs did not change")
+	noPrune        = flag.Bool("no-prune", false, "Keep the outputs of components whose input was removed")
+	check          = flag.Bool("check", false, "Compare the generated files with -out without writing them, failing if they differ")
+	watch          = flag.Bool("watch", false, "Keep running and rebuild the components whose files change")
+	timeout        = flag.Duration("timeout", 0, "Abort the build after this long, no timeout by default or in watch mode")
+	watchInterval  = flag.Duration("watch-interval", 500*time.Millisecond, "How often the input is polled for changes in watch mode")
 )
 
 func main() {
@@ -43,8 +66,27 @@ func main() {
 	buildOpts := &builder.BuildOptions{
 		QueueDir:       *queueDir,
 		OutputBuildDir: *outputBuildDir,
-		WaitGroup:      &errgroup.Group{},
+		Jobs:           *jobs,
 		Hash:           *hash,
+		TimeFormat:     *timeFormat,
+		JSONNaming:     *jsonNaming,
+		RuntimePackage: *runtimePackage,
+		NoCache:        *noCache,
+		NoPrune:        *noPrune,
+		PropPrefix:     *propPrefix,
+		LoopPrefix:     *loopPrefix,
+		MapPrefix:      *mapPrefix,
+		IfPrefix:       *ifPrefix,
+		ElifPrefix:     *elifPrefix,
+		ElseMarker:     *elseMarker,
+		EachDirective:  *eachDirective,
+		EndDirective:   *endDirective,
+		MaxInputSize:   *maxInputSize,
+		Warnings:       os.Stderr,
+	}
+	if *keepGoing {
+		buildOpts.ContinueOnError = true
+		buildOpts.Summary = os.Stderr
 	}
 
 	if buildOpts.QueueDir == "" {
@@ -57,6 +99,64 @@ func main() {
 	// Resolve the paths to the queue and output relative to the executable path
 	buildOpts.QueueDir = path.Join(calledFromDir, buildOpts.QueueDir)
 	buildOpts.OutputBuildDir = path.Join(calledFromDir, buildOpts.OutputBuildDir)
+	// os.DirFS only fails once the input is walked, with a less clear error
+	if info, err := os.Stat(buildOpts.QueueDir); os.IsNotExist(err) {
+		fmt.Fprintf(os.Stderr, "queue directory does not exist: %s\n", buildOpts.QueueDir)
+		os.Exit(1)
+	} else if err == nil && !info.IsDir() {
+		fmt.Fprintf(os.Stderr, "queue directory is not a directory: %s\n", buildOpts.QueueDir)
+		os.Exit(1)
+	}
+	buildOpts.Input = os.DirFS(buildOpts.QueueDir)
+	buildOpts.Output = builder.DirOutput(buildOpts.OutputBuildDir)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if *timeout > 0 && !*watch {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
 
-	builder.Build(buildOpts)
+	if *check {
+		changes, err := builder.Check(ctx, buildOpts)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		for _, change := range changes {
+			fmt.Printf("%s %s\n", change.Kind, change.Name)
+		}
+		for _, change := range changes {
+			fmt.Print(builder.UnifiedDiff(change.Name, change.Old, change.New))
+		}
+		if len(changes) > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *watch {
+		err := builder.Watch(ctx, buildOpts, *watchInterval, func(event *builder.WatchEvent) {
+			for _, component := range event.Built {
+				fmt.Fprintln(os.Stderr, "built", component)
+			}
+			for _, component := range event.Removed {
+				fmt.Fprintln(os.Stderr, "removed", component)
+			}
+			if event.Err != nil {
+				fmt.Fprintln(os.Stderr, event.Err)
+			}
+		})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if err := builder.Build(ctx, buildOpts); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,212 @@
+// Package js renders Go values as text the way JavaScript, and therefore
+// Svelte, does. Templates generated by svelte-ssr-to-templ use it, so that the
+// server rendered HTML matches the HTML the Svelte component hydrates with.
+package js
+
+import (
+	"cmp"
+	"iter"
+	"math"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Stringify returns String(value) for the JSON encoding of v, except that
+// null becomes the empty string like in Svelte's `{value}`. Times are
+// formatted the way they are encoded in JSON. Integers beyond ±2^53 are
+// rounded like JSON.parse rounds them to a double.
+func Stringify(v any) string {
+	return StringifyTime(v, time.RFC3339Nano)
+}
+
+// StringifyTime is Stringify, formatting times with the layout.
+func StringifyTime(v any, layout string) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case int:
+		return formatInt(int64(v))
+	case int64:
+		return formatInt(v)
+	case uint:
+		return formatUint(uint64(v))
+	case float64:
+		return FormatNumber(v)
+	case time.Time:
+		return v.Format(layout)
+	}
+	return stringifyValue(reflect.ValueOf(v), layout)
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func stringifyValue(v reflect.Value, layout string) string {
+	if v.Type() == timeType {
+		return v.Interface().(time.Time).Format(layout)
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return ""
+		}
+		return stringifyValue(v.Elem(), layout)
+	case reflect.String:
+		return v.String()
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return formatInt(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return formatUint(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return FormatNumber(v.Float())
+	case reflect.Slice, reflect.Array:
+		// Array.prototype.join, which also renders null elements as empty
+		parts := make([]string, v.Len())
+		for i := range parts {
+			parts[i] = stringifyValue(v.Index(i), layout)
+		}
+		return strings.Join(parts, ",")
+	case reflect.Map:
+		if v.IsNil() {
+			return ""
+		}
+		return "[object Object]"
+	case reflect.Struct:
+		return "[object Object]"
+	default:
+		return ""
+	}
+}
+
+// The integers JavaScript numbers represent exactly
+const maxSafeInteger = 1<<53 - 1
+
+func formatInt(i int64) string {
+	if i > maxSafeInteger || i < -maxSafeInteger {
+		return FormatNumber(float64(i))
+	}
+	return strconv.FormatInt(i, 10)
+}
+
+func formatUint(u uint64) string {
+	if u > maxSafeInteger {
+		return FormatNumber(float64(u))
+	}
+	return strconv.FormatUint(u, 10)
+}
+
+// Entries iterates over the map in the order JavaScript iterates over the
+// object parsed from its JSON: keys that are array indices first in numeric
+// order, then the other keys in the sorted order they are encoded in.
+func Entries[M ~map[K]V, K comparable, V any](m M) iter.Seq2[K, V] {
+	type entry struct {
+		key     K
+		name    string // The key in the JSON object
+		index   uint64
+		isIndex bool
+	}
+	entries := make([]entry, 0, len(m))
+	for key := range m {
+		name := jsonKey(key)
+		index, err := strconv.ParseUint(name, 10, 32)
+		isIndex := err == nil && index < math.MaxUint32 && strconv.FormatUint(index, 10) == name
+		entries = append(entries, entry{key, name, index, isIndex})
+	}
+	slices.SortFunc(entries, func(a, b entry) int {
+		switch {
+		case a.isIndex && b.isIndex:
+			return cmp.Compare(a.index, b.index)
+		case a.isIndex:
+			return -1
+		case b.isIndex:
+			return 1
+		}
+		return strings.Compare(a.name, b.name)
+	})
+
+	return func(yield func(K, V) bool) {
+		for _, entry := range entries {
+			if !yield(entry.key, m[entry.key]) {
+				return
+			}
+		}
+	}
+}
+
+// FormatNumber returns the shortest representation of f that round trips,
+// following Number.prototype.toString().
+func FormatNumber(f float64) string {
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "Infinity"
+	case math.IsInf(f, -1):
+		return "-Infinity"
+	case f == 0:
+		// Including negative zero
+		return "0"
+	}
+
+	var sign string
+	if f < 0 {
+		sign = "-"
+		f = -f
+	}
+
+	// Split `d.ddde±x` into the digits and the position of the decimal point
+	exponential := strconv.FormatFloat(f, 'e', -1, 64)
+	mantissa, exponent, _ := strings.Cut(exponential, "e")
+	digits := strings.Replace(mantissa, ".", "", 1)
+	e, _ := strconv.Atoi(exponent)
+	k := len(digits)
+	n := e + 1
+
+	switch {
+	case k <= n && n <= 21:
+		return sign + digits + strings.Repeat("0", n-k)
+	case 0 < n && n <= 21:
+		return sign + digits[:n] + "." + digits[n:]
+	case -6 < n && n <= 0:
+		return sign + "0." + strings.Repeat("0", -n) + digits
+	}
+
+	exponentSign := "+"
+	if n-1 < 0 {
+		exponentSign = "-"
+	}
+	exponent = exponentSign + strconv.Itoa(abs(n-1))
+	if k == 1 {
+		return sign + digits + "e" + exponent
+	}
+	return sign + digits[:1] + "." + digits[1:] + "e" + exponent
+}
+
+// The key of the map in its JSON encoding. Keys are strings, so unlike numbers
+// large integers keep all their digits.
+func jsonKey(key any) string {
+	v := reflect.ValueOf(key)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(v.Uint(), 10)
+	}
+	return Stringify(key)
+}
+
+func abs(i int) int {
+	if i < 0 {
+		return -i
+	}
+	return i
+}
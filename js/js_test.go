@@ -0,0 +1,155 @@
+package js
+
+import (
+	"math"
+	"slices"
+	"testing"
+	"time"
+)
+
+// Variables, since constant arithmetic is exact
+var tenth, fifth = 0.1, 0.2
+
+func TestFormatNumber(t *testing.T) {
+	tests := []struct {
+		in   float64
+		want string
+	}{
+		{0, "0"},
+		{math.Copysign(0, -1), "0"},
+		{1, "1"},
+		{-1.5, "-1.5"},
+		{tenth + fifth, "0.30000000000000004"},
+		{123456789, "123456789"},
+		{9007199254740992, "9007199254740992"},
+		{1e20, "100000000000000000000"},
+		{1e21, "1e+21"},
+		{1.5e300, "1.5e+300"},
+		{math.MaxFloat64, "1.7976931348623157e+308"},
+		{0.000001, "0.000001"},
+		{0.0000001, "1e-7"},
+		{1.23e-18, "1.23e-18"},
+		{5e-324, "5e-324"},
+		{math.NaN(), "NaN"},
+		{math.Inf(1), "Infinity"},
+		{math.Inf(-1), "-Infinity"},
+	}
+	for _, test := range tests {
+		if got := FormatNumber(test.in); got != test.want {
+			t.Errorf("FormatNumber(%v) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestStringify(t *testing.T) {
+	at := time.Date(2024, 1, 2, 3, 4, 5, 6, time.UTC)
+	tests := []struct {
+		name string
+		in   any
+		want string
+	}{
+		{"nil", nil, ""},
+		{"string", "a<b", "a<b"},
+		{"bool", true, "true"},
+		{"int", -5, "-5"},
+		{"int64", int64(1) << 50, "1125899906842624"},
+		// Integers beyond ±2^53 are parsed from the JSON as doubles
+		{"unsafe int64", int64(9007199254740993), "9007199254740992"},
+		{"unsafe negative int64", int64(-9007199254740993), "-9007199254740992"},
+		{"large int64", int64(1) << 60, "1152921504606847000"},
+		{"uint", uint(7), "7"},
+		{"large uint", uint(1) << 63, "9223372036854776000"},
+		{"unsafe elements", []int64{1, 9007199254740993}, "1,9007199254740992"},
+		{"float", tenth + fifth, "0.30000000000000004"},
+		{"large float", 1e21, "1e+21"},
+		{"negative zero", math.Copysign(0, -1), "0"},
+		{"time", at, "2024-01-02T03:04:05.000000006Z"},
+		{"nil pointer", (*int)(nil), ""},
+		{"pointer", &at, "2024-01-02T03:04:05.000000006Z"},
+		{"nil slice", []int(nil), ""},
+		{"empty slice", []int{}, ""},
+		{"slice", []int{1, 2}, "1,2"},
+		{"nested slice", [][]int{{1, 2}, {3}}, "1,2,3"},
+		{"null elements", []any{1, nil, "a"}, "1,,a"},
+		{"float elements", []float64{math.NaN(), 1e21, -0.5}, "NaN,1e+21,-0.5"},
+		{"nil map", map[string]int(nil), ""},
+		{"empty map", map[string]int{}, "[object Object]"},
+		{"struct", struct{ A int }{1}, "[object Object]"},
+		{"slice of structs", []struct{}{{}, {}}, "[object Object],[object Object]"},
+	}
+	for _, test := range tests {
+		if got := Stringify(test.in); got != test.want {
+			t.Errorf("%s: Stringify(%#v) = %q, want %q", test.name, test.in, got, test.want)
+		}
+	}
+}
+
+func TestStringifyTime(t *testing.T) {
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		in   any
+		want string
+	}{
+		{at, "2024-01-02"},
+		{[]time.Time{at, at.AddDate(0, 0, 1)}, "2024-01-02,2024-01-03"},
+		{[]*time.Time{nil, &at}, ",2024-01-02"},
+		{"2024", "2024"},
+	}
+	for _, test := range tests {
+		if got := StringifyTime(test.in, time.DateOnly); got != test.want {
+			t.Errorf("StringifyTime(%#v) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestEntries(t *testing.T) {
+	strings := map[string]int{
+		"b": 1, "10": 2, "a": 3, "2": 4, "01": 5, "-1": 6, "4294967295": 7, "4294967294": 8, "1.5": 9,
+	}
+	var keys []string
+	var values []int
+	for key, value := range Entries(strings) {
+		keys = append(keys, key)
+		values = append(values, value)
+	}
+	// Array indices first, then the other keys as sorted in the JSON
+	wantKeys := []string{"2", "10", "4294967294", "-1", "01", "1.5", "4294967295", "a", "b"}
+	if !slices.Equal(keys, wantKeys) {
+		t.Errorf("keys = %q, want %q", keys, wantKeys)
+	}
+	for i, key := range keys {
+		if values[i] != strings[key] {
+			t.Errorf("value of %q = %d, want %d", key, values[i], strings[key])
+		}
+	}
+
+	var ints []int
+	for key := range Entries(map[int]bool{10: true, -3: true, 2: true, 1: true}) {
+		ints = append(ints, key)
+	}
+	if want := []int{1, 2, 10, -3}; !slices.Equal(ints, want) {
+		t.Errorf("keys = %v, want %v", ints, want)
+	}
+
+	// Keys are strings in the JSON, which keep all the digits
+	var large []int64
+	for key := range Entries(map[int64]bool{9007199254740993: true, 9007199254740992: true}) {
+		large = append(large, key)
+	}
+	if want := []int64{9007199254740992, 9007199254740993}; !slices.Equal(large, want) {
+		t.Errorf("keys = %v, want %v", large, want)
+	}
+
+	count := 0
+	for range Entries(map[string]int{"a": 1, "b": 2}) {
+		count++
+		break
+	}
+	if count != 1 {
+		t.Errorf("iterated %d times after break", count)
+	}
+
+	for range Entries(map[string]int(nil)) {
+		t.Error("iterated over a nil map")
+	}
+}